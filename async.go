@@ -0,0 +1,58 @@
+package remotewrite
+
+import (
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/v2/js/promises"
+)
+
+// StoreAsync is the asynchronous version of Store. The request is encoded and sent in the background,
+// so a VU can keep several requests in flight, and the returned promise resolves with the response.
+func (c *Client) StoreAsync(ts []Timeseries, metadata []Metadata) *sobek.Promise {
+	promise, resolve, reject := promises.New(c.vu)
+
+	go func() {
+		res, err := c.Store(ts, metadata)
+		if err != nil {
+			reject(err)
+
+			return
+		}
+
+		resolve(res)
+	}()
+
+	return promise
+}
+
+// StoreFromPrecompiledTemplatesAsync is the asynchronous version of StoreFromPrecompiledTemplates.
+// The series are generated, encoded and sent in the background, and the returned promise resolves
+// with the response.
+func (c *Client) StoreFromPrecompiledTemplatesAsync(
+	minValue, maxValue int,
+	timestamp int64, minSeriesID, maxSeriesID int,
+	template *labelTemplates,
+	options TemplateOptions,
+) *sobek.Promise {
+	promise, resolve, reject := promises.New(c.vu)
+
+	go func() {
+		t, err := template.acquire()
+		if err != nil {
+			reject(err)
+
+			return
+		}
+		defer template.release(t)
+
+		res, err := c.StoreFromPrecompiledTemplates(minValue, maxValue, timestamp, minSeriesID, maxSeriesID, t, options)
+		if err != nil {
+			reject(err)
+
+			return
+		}
+
+		resolve(res)
+	}()
+
+	return promise
+}
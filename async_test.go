@@ -0,0 +1,69 @@
+package remotewrite
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/grafana/sobek"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreAsync(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t)
+	callbacks := make(chan func() error, 10)
+	s.vu.RuntimeField = sobek.New()
+	s.vu.RegisterCallbackField = func() func(func() error) {
+		return func(f func() error) { callbacks <- f }
+	}
+
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+	template, err := compileLabelTemplates(map[string]string{"__name__": "metric_${series_id/10}"})
+	require.NoError(t, err)
+
+	promises := []*sobek.Promise{
+		c.StoreAsync([]Timeseries{{
+			Labels:  []Label{{Name: "__name__", Value: "metric"}},
+			Samples: []Sample{{Value: 42, Timestamp: 1000}},
+		}}, nil),
+		c.StoreFromPrecompiledTemplatesAsync(1, 2, 1000, 0, 100, template, TemplateOptions{}),
+		c.StoreFromPrecompiledTemplatesAsync(1, 2, 1000, 100, 200, template, TemplateOptions{}),
+	}
+
+	for range promises {
+		require.NoError(t, (<-callbacks)())
+	}
+
+	for _, p := range promises {
+		require.Equal(t, sobek.PromiseStateFulfilled, p.State())
+
+		res, ok := p.Result().Export().(Response)
+		require.True(t, ok)
+		require.Equal(t, http.StatusOK, res.Status)
+	}
+}
+
+func TestStoreAsyncRejected(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t)
+	callbacks := make(chan func() error, 1)
+	s.vu.RuntimeField = sobek.New()
+	s.vu.RegisterCallbackField = func() func(func() error) {
+		return func(f func() error) { callbacks <- f }
+	}
+	s.vu.StateField = nil
+
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	p := c.StoreAsync(nil, nil)
+	require.NoError(t, (<-callbacks)())
+	require.Equal(t, sobek.PromiseStateRejected, p.State())
+}
@@ -0,0 +1,173 @@
+package remotewrite
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// credentialsRefreshInterval is how long the credentials read from files are used before the files
+// are read again, so that long tests survive the rotation of credentials.
+const credentialsRefreshInterval = time.Minute
+
+// BasicAuthConfig holds the basic authentication credentials of the client, as in the remote_write
+// configuration of Prometheus.
+type BasicAuthConfig struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password_file"` //nolint:tagliatelle // sobek use snake case for JSON keys
+}
+
+// authorizer sets the Authorization header of the requests from the configured credentials.
+type authorizer struct {
+	// username and password are the basic auth credentials, password is nil without basic auth.
+	username    string
+	password    *secret
+	bearerToken *secret
+	oauth2      *tokenSource
+	sigv4       *sigV4Signer
+}
+
+func newAuthorizer(cfg *Config) (*authorizer, error) {
+	a := new(authorizer)
+
+	if cfg.BearerToken != "" && cfg.BearerTokenFile != "" {
+		return nil, errors.New("at most one of bearer_token and bearer_token_file can be set")
+	}
+
+	if cfg.BearerToken != "" || cfg.BearerTokenFile != "" {
+		a.bearerToken = newSecret(cfg.BearerToken, cfg.BearerTokenFile)
+	}
+
+	if cfg.BasicAuth != nil {
+		if a.bearerToken != nil {
+			return nil, errors.New("at most one of basic_auth and bearer_token can be set")
+		}
+
+		if cfg.BasicAuth.Password != "" && cfg.BasicAuth.PasswordFile != "" {
+			return nil, errors.New("at most one of basic_auth password and password_file can be set")
+		}
+
+		a.username = cfg.BasicAuth.Username
+		a.password = newSecret(cfg.BasicAuth.Password, cfg.BasicAuth.PasswordFile)
+	}
+
+	if cfg.OAuth2 != nil {
+		if a.bearerToken != nil || a.password != nil {
+			return nil, errors.New("at most one of basic_auth, bearer_token and oauth2 can be set")
+		}
+
+		var err error
+
+		a.oauth2, err = sharedTokenSource(cfg.OAuth2)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.SigV4 != nil {
+		if a.bearerToken != nil || a.password != nil || a.oauth2 != nil {
+			return nil, errors.New("at most one of basic_auth, bearer_token, oauth2 and sigv4 can be set")
+		}
+
+		var err error
+
+		a.sigv4, err = newSigV4Signer(cfg.SigV4)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// authorize sets the Authorization header of the request, overwriting the one of the configured headers.
+// It must be called once all the other headers of the request are set, since SigV4 signs them along
+// with the body.
+//
+// The OAuth 2.0 tokens are fetched, and the AWS roles assumed, with transport, the one of the VU,
+// rather than with the default transport of Go, so that these requests follow the TLS, hosts and
+// blocked hostnames options of the test like the remote-write requests.
+func (a *authorizer) authorize(r *http.Request, body []byte, transport http.RoundTripper) error {
+	switch {
+	case a.password != nil:
+		password, err := a.password.get()
+		if err != nil {
+			return err
+		}
+
+		r.SetBasicAuth(a.username, password)
+	case a.bearerToken != nil:
+		token, err := a.bearerToken.get()
+		if err != nil {
+			return err
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+	case a.oauth2 != nil:
+		authorization, err := a.oauth2.authorization(r.Context(), transport)
+		if err != nil {
+			return err
+		}
+
+		r.Header.Set("Authorization", authorization)
+	case a.sigv4 != nil:
+		return a.sigv4.sign(r, body, transport)
+	}
+
+	return nil
+}
+
+// secret is a credential either given in the configuration, or read from a file and read again
+// once it's older than refresh.
+type secret struct {
+	path    string
+	refresh time.Duration
+
+	mu     sync.Mutex
+	value  string
+	readAt time.Time
+}
+
+func newSecret(value, path string) *secret {
+	return &secret{value: value, path: path, refresh: credentialsRefreshInterval}
+}
+
+func (s *secret) get() (string, error) {
+	if s.path == "" {
+		return s.value, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.readAt.IsZero() && time.Since(s.readAt) < s.refresh {
+		return s.value, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read credentials")
+	}
+
+	s.value = strings.TrimSpace(string(data))
+	s.readAt = time.Now()
+
+	return s.value, nil
+}
+
+// getAuthorizer returns the authorizer of the client, creating it on first use for clients
+// that weren't built by the JS constructor.
+func (c *Client) getAuthorizer() (*authorizer, error) {
+	c.authOnce.Do(func() {
+		if c.auth == nil {
+			c.auth, c.authErr = newAuthorizer(c.cfg)
+		}
+	})
+
+	return c.auth, c.authErr
+}
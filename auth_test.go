@@ -0,0 +1,189 @@
+package remotewrite
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/v2/metrics"
+)
+
+// newAuthServer returns a test server keeping the Authorization headers of the requests.
+func newAuthServer(t *testing.T) (*testServer, func() []string) {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		headers []string
+	)
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Get("Authorization"))
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return s, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return headers
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+
+	testcases := []struct {
+		name     string
+		cfg      Config
+		expected string
+	}{
+		{
+			name:     "basic auth",
+			cfg:      Config{BasicAuth: &BasicAuthConfig{Username: "user", Password: "secret"}},
+			expected: "Basic dXNlcjpzZWNyZXQ=",
+		},
+		{
+			name:     "basic auth password file",
+			cfg:      Config{BasicAuth: &BasicAuthConfig{Username: "user", PasswordFile: passwordFile}},
+			expected: "Basic dXNlcjpzZWNyZXQ=",
+		},
+		{
+			name:     "bearer token",
+			cfg:      Config{BearerToken: "token"},
+			expected: "Bearer token",
+		},
+		{
+			name:     "bearer token file",
+			cfg:      Config{BearerTokenFile: passwordFile},
+			expected: "Bearer secret",
+		},
+		{
+			name: "overrides headers",
+			cfg: Config{
+				BearerToken: "token",
+				Headers:     map[string]string{"Authorization": "Bearer header"},
+			},
+			expected: "Bearer token",
+		},
+		{
+			name:     "none",
+			expected: "",
+		},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, headers := newAuthServer(t)
+
+			cfg := testcase.cfg
+			cfg.Url = s.server.URL
+			cfg.Timeout = "10s"
+
+			c := &Client{cfg: &cfg, vu: s.vu}
+
+			_, err := c.Store([]Timeseries{{
+				Labels:  []Label{{Name: "__name__", Value: "metric"}},
+				Samples: []Sample{{Value: 1, Timestamp: 1000}},
+			}}, nil)
+			require.NoError(t, err)
+			require.Equal(t, []string{testcase.expected}, headers())
+		})
+	}
+}
+
+func TestAuthorizationURLCredentials(t *testing.T) {
+	t.Parallel()
+
+	s, headers := newAuthServer(t)
+
+	samples := make(chan metrics.SampleContainer, 100)
+	s.vu.StateField.Samples = samples
+	s.vu.StateField.Options.SystemTags = metrics.NewSystemTagSet(metrics.TagURL)
+
+	c := &Client{
+		cfg: &Config{Url: strings.Replace(s.server.URL, "://", "://user:secret@", 1), Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	res, err := c.Store([]Timeseries{{
+		Labels:  []Label{{Name: "__name__", Value: "metric"}},
+		Samples: []Sample{{Value: 1, Timestamp: 1000}},
+	}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Basic dXNlcjpzZWNyZXQ="}, headers())
+	require.Equal(t, s.server.URL, res.Request.URL)
+
+	close(samples)
+
+	urls := 0
+
+	for container := range samples {
+		for _, sample := range container.GetSamples() {
+			if u, ok := sample.Tags.Get("url"); ok {
+				require.NotContains(t, u, "secret")
+				require.NotContains(t, u, "user")
+
+				urls++
+			}
+		}
+	}
+
+	require.Positive(t, urls)
+}
+
+func TestAuthorizerConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, cfg := range []Config{
+		{BearerToken: "token", BearerTokenFile: "token"},
+		{BearerToken: "token", BasicAuth: &BasicAuthConfig{Username: "user"}},
+		{BasicAuth: &BasicAuthConfig{Password: "secret", PasswordFile: "password"}},
+	} {
+		_, err := newAuthorizer(&cfg)
+		require.Error(t, err)
+	}
+
+	a, err := newAuthorizer(&Config{BearerTokenFile: filepath.Join(t.TempDir(), "missing")})
+	require.NoError(t, err)
+	require.Error(t, a.authorize(new(http.Request), nil, http.DefaultTransport))
+}
+
+func TestSecretRefresh(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("first"), 0o600))
+
+	s := newSecret("", path)
+
+	value, err := s.get()
+	require.NoError(t, err)
+	require.Equal(t, "first", value)
+
+	// the file is only read again once the refresh interval has elapsed
+	require.NoError(t, os.WriteFile(path, []byte("second"), 0o600))
+
+	value, err = s.get()
+	require.NoError(t, err)
+	require.Equal(t, "first", value)
+
+	s.readAt = time.Now().Add(-credentialsRefreshInterval)
+
+	value, err = s.get()
+	require.NoError(t, err)
+	require.Equal(t, "second", value)
+}
@@ -0,0 +1,126 @@
+package remotewrite
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BackfillOptions configures the historical samples generated by BackfillFromPrecompiledTemplates.
+type BackfillOptions struct {
+	// Start and End bound the timestamps of the samples, in milliseconds, End excluded.
+	Start int64
+	End   int64
+	// Step is the interval between two samples of a series, e.g. "15s".
+	Step string
+	// MaxSamplesPerRequest bounds the size of the requests, 2000 samples by default.
+	MaxSamplesPerRequest int
+	// Value selects how the values of the series are generated, randomly by default.
+	Value ValueOptions
+	// Seed overrides the client's seed.
+	Seed int64
+}
+
+// BackfillResponse summarizes the requests sent by a backfill.
+type BackfillResponse struct {
+	// Requests is the number of requests sent, and Samples the number of samples they carried.
+	Requests int
+	Samples  int
+	// Failed is the number of requests answered with a non-2xx status.
+	Failed int
+	// Status is the HTTP status of the last request.
+	Status int
+}
+
+// BackfillFromPrecompiledTemplates generates the samples of the series of the range at every step
+// from options.Start to options.End, and streams them in requests of up to MaxSamplesPerRequest
+// samples. The samples are sent in the order of their timestamps, and the backfill goes on when
+// requests are rejected, to measure how receivers deal with old samples.
+func (c *Client) BackfillFromPrecompiledTemplates(
+	minValue, maxValue int,
+	minSeriesID, maxSeriesID int,
+	template *labelTemplates,
+	options BackfillOptions,
+) (BackfillResponse, error) {
+	state := c.vu.State()
+	if state == nil {
+		return BackfillResponse{}, errors.New("State is nil")
+	}
+
+	step, maxSamples, err := options.validate(minSeriesID, maxSeriesID)
+	if err != nil {
+		return BackfillResponse{}, err
+	}
+
+	r := c.newRand(state, options.Seed, minSeriesID)
+
+	template.setVars(newTemplateVars(c.vu.Context(), state))
+
+	// every request carries a window of samples of every series, as many as fit in a request,
+	// and the series of the range are split between requests when they don't fit in one
+	window := int64(max(maxSamples/(maxSeriesID-minSeriesID), 1))
+	chunk := max(maxSamples/int(window), 1)
+
+	var res BackfillResponse
+
+	for first := options.Start; first < options.End; first += window * step {
+		samples := min(window, (options.End-first+step-1)/step)
+		generate := TemplateOptions{Value: options.Value, Samples: int(samples), Step: options.Step}
+
+		for from := minSeriesID; from < maxSeriesID; from += chunk {
+			start := time.Now()
+
+			// the samples of the window end at the timestamp of the call
+			buf, stats, err := generateFromPrecompiledTemplates(
+				r, minValue, maxValue, first+(samples-1)*step, from, min(maxSeriesID, from+chunk), template, generate,
+			)
+			if err != nil {
+				return res, err
+			}
+
+			stats.start = start
+
+			response, err := c.sendGenerated(state, buf, stats)
+			if err != nil {
+				return res, err
+			}
+
+			res.Requests++
+			res.Samples += stats.samples
+			res.Status = response.Status
+
+			if !ResponseCallback(response.Status) {
+				res.Failed++
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// validate returns the step in milliseconds and the largest number of samples of a request.
+func (options BackfillOptions) validate(minSeriesID, maxSeriesID int) (int64, int, error) {
+	if minSeriesID >= maxSeriesID {
+		return 0, 0, errors.New("the series ID range of a backfill can't be empty")
+	}
+
+	if options.End <= options.Start {
+		return 0, 0, errors.New("the end of a backfill must be after its start")
+	}
+
+	step, err := parseValueDuration(options.Step, 0)
+	if err != nil || step < time.Millisecond {
+		return 0, 0, errors.New("the step of a backfill must be a duration of at least 1ms")
+	}
+
+	maxSamples := options.MaxSamplesPerRequest
+	if maxSamples == 0 {
+		maxSamples = defaultQueueMaxSamplesPerSend
+	}
+
+	if maxSamples < 0 {
+		return 0, 0, errors.New("max_samples_per_request can't be negative")
+	}
+
+	return step.Milliseconds(), maxSamples, nil
+}
@@ -0,0 +1,128 @@
+package remotewrite
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfill(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		name       string
+		maxSamples int
+		// samples is the number of samples of the series of every request.
+		samples []int
+		series  []int
+	}{
+		{name: "several samples per series", maxSamples: 20, samples: []int{2, 2}, series: []int{10, 10}},
+		{name: "windows cut by the end", maxSamples: 30, samples: []int{3, 1}, series: []int{10, 10}},
+		{name: "series split", maxSamples: 4, samples: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, series: []int{
+			4, 4, 2, 4, 4, 2, 4, 4, 2, 4, 4, 2,
+		}},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, requests := newRecordingServer(t)
+			c := &Client{
+				cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+				vu:  s.vu,
+			}
+
+			template, err := compileLabelTemplates(map[string]string{"__name__": "counter_${series_id}"})
+			require.NoError(t, err)
+
+			res, err := c.BackfillFromPrecompiledTemplates(0, 100, 0, 10, template, BackfillOptions{
+				Start:                1_000_000,
+				End:                  1_060_000,
+				Step:                 "15s",
+				MaxSamplesPerRequest: testcase.maxSamples,
+				Value:                ValueOptions{Type: "counter"},
+			})
+			require.NoError(t, err)
+			require.Equal(t, BackfillResponse{Requests: len(testcase.series), Samples: 40, Status: http.StatusOK}, res)
+
+			reqs := requests()
+			require.Len(t, reqs, len(testcase.series))
+
+			last := make(map[string]prompb.Sample)
+
+			for i, req := range reqs {
+				require.Len(t, req.Timeseries, testcase.series[i])
+
+				for _, ts := range req.Timeseries {
+					require.Len(t, ts.Samples, testcase.samples[i])
+
+					name := ts.Labels[0].Value
+
+					// the samples of every series are sent in the order of their timestamps, a step apart
+					for _, sample := range ts.Samples {
+						previous, seen := last[name]
+						if seen {
+							require.Equal(t, previous.Timestamp+15000, sample.Timestamp, name)
+							require.Greater(t, sample.Value, previous.Value, name)
+						} else {
+							require.Equal(t, int64(1_000_000), sample.Timestamp, name)
+						}
+
+						last[name] = sample
+					}
+				}
+			}
+
+			require.Len(t, last, 10)
+			require.Equal(t, int64(1_045_000), last["counter_9"].Timestamp)
+		})
+	}
+}
+
+func TestBackfillRejected(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "gauge_${series_id}"})
+	require.NoError(t, err)
+
+	// the backfill goes on when the samples are rejected
+	res, err := c.BackfillFromPrecompiledTemplates(0, 100, 0, 4, template, BackfillOptions{
+		Start:                0,
+		End:                  10000,
+		Step:                 "1s",
+		MaxSamplesPerRequest: 20,
+	})
+	require.NoError(t, err)
+	require.Equal(t, BackfillResponse{Requests: 2, Samples: 40, Failed: 2, Status: http.StatusBadRequest}, res)
+}
+
+func TestBackfillOptions(t *testing.T) {
+	t.Parallel()
+
+	_, maxSamples, err := BackfillOptions{End: 1, Step: "1s"}.validate(0, 1)
+	require.NoError(t, err)
+	require.Equal(t, defaultQueueMaxSamplesPerSend, maxSamples)
+
+	for _, options := range []BackfillOptions{
+		{Start: 1, End: 1, Step: "1s"},
+		{End: 1},
+		{End: 1, Step: "1us"},
+		{End: 1, Step: "1s", MaxSamplesPerRequest: -1},
+	} {
+		_, _, err := options.validate(0, 1)
+		require.Error(t, err, options)
+	}
+
+	_, _, err = BackfillOptions{End: 1, Step: "1s"}.validate(1, 1)
+	require.ErrorContains(t, err, "can't be empty")
+}
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"go.k6.io/k6/v2/js/modulestest"
 	"go.k6.io/k6/v2/lib"
@@ -78,14 +79,22 @@ type testServer struct {
 func newTestServer(tb testing.TB) *testServer {
 	tb.Helper()
 
+	return newTestServerWithHandler(tb, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func newTestServerWithHandler(tb testing.TB, handler http.HandlerFunc) *testServer {
+	tb.Helper()
+
 	ts := &testServer{
 		count: new(int64),
 	}
 
 	ts.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _ = io.Copy(io.Discard, r.Body)
-
-		w.WriteHeader(http.StatusOK)
+		handler(w, r)
 		atomic.AddInt64(ts.count, 1)
 	}))
 	registry := metrics.NewRegistry()
@@ -106,6 +115,11 @@ func newTestServer(tb testing.TB) *testServer {
 	ts.vu.StateField.BuiltinMetrics = metrics.RegisterBuiltinMetrics(registry)
 	ts.vu.StateField.Tags = lib.NewVUStateTags(registry.RootTagSet())
 
+	// the failed requests are logged when the throw option isn't set
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ts.vu.StateField.Logger = logger
+
 	go func() {
 		for range ch { //nolint:revive // we just need to drain the channel
 		}
@@ -130,7 +144,7 @@ func BenchmarkStoreFromPrecompiledTemplates(b *testing.B) {
 	b.ResetTimer()
 
 	for i := range b.N {
-		_, err := c.StoreFromPrecompiledTemplates(i, i+10, int64(i), 0, 100000, template)
+		_, err := c.StoreFromPrecompiledTemplates(i, i+10, int64(i), 0, 100000, template, TemplateOptions{})
 		require.NoError(b, err)
 	}
 
@@ -151,7 +165,7 @@ func BenchmarkStoreFromTemplates(b *testing.B) {
 	b.ResetTimer()
 
 	for i := range b.N {
-		_, err := c.StoreFromTemplates(i, i+10, int64(i), 0, 100000, benchmarkLabels)
+		_, err := c.StoreFromTemplates(i, i+10, int64(i), 0, 100000, benchmarkLabels, TemplateOptions{})
 		require.NoError(b, err)
 	}
 
@@ -169,7 +183,7 @@ func BenchmarkGenerateFromPrecompiledTemplates(b *testing.B) {
 
 		for pb.Next() {
 			i++
-			_, _ = generateFromPrecompiledTemplates(r, i, i+10, int64(i), 0, 100000, template)
+			_, _, _ = generateFromPrecompiledTemplates(r, i, i+10, int64(i), 0, 100000, template, TemplateOptions{})
 		}
 	})
 }
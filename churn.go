@@ -0,0 +1,151 @@
+package remotewrite
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/value"
+)
+
+const (
+	// churnSeed selects the churned series independently from the distribution functions.
+	churnSeed = 0x0c42_5eed_0c42_5eed
+	// churnIDStride separates the series IDs of successive generations. The IDs of the ranges are
+	// below it, so that the churned series of a range never take the IDs of another range, such
+	// as the one of the next VU. It's 2^32 with 64-bit ints, and 2^16 on 32-bit platforms.
+	churnIDStride = 1 << (bits.UintSize / 2)
+	// churnGenerations bounds the generations so that the churned IDs fit in a positive int, they wrap around after it.
+	churnGenerations = 1 << (bits.UintSize/2 - 1)
+)
+
+// ChurnOptions rotates a fraction of the series generated from templates to new series IDs, the way
+// restarting pods replace their series with new ones while the old ones go stale.
+type ChurnOptions struct {
+	// Fraction is the share of the series ID range that is rotated, from 0 (the default, no churn) to 1.
+	Fraction float64
+	// Every is the interval between two rotations, aligned on the timestamps of the samples, e.g. "10m".
+	Every string
+	// EveryIterations rotates the series every N iterations of the VU instead of every interval.
+	EveryIterations int
+	// StaleMarkers sends a staleness marker for every retired series, along with the first samples
+	// of the series replacing them.
+	StaleMarkers bool
+}
+
+// churn maps the series IDs of a range to the ones of their current generation. The churned
+// series of generation g are shifted by g times churnIDStride, so that the IDs of different
+// generations never overlap, nor the ones of other ranges.
+type churn struct {
+	fraction     float64
+	size         int
+	generation   int64
+	staleMarkers bool
+}
+
+// compile returns the churn of the series of the range at the given timestamp and iteration.
+func (options ChurnOptions) compile(minSeriesID, maxSeriesID int, timestamp, iteration int64) (churn, error) {
+	if options.Fraction < 0 || options.Fraction > 1 {
+		return churn{}, errors.New("the churn fraction must be between 0 and 1")
+	}
+
+	if options.Fraction == 0 {
+		return churn{}, nil
+	}
+
+	if minSeriesID < 0 || maxSeriesID > churnIDStride {
+		return churn{}, errors.New("churn expects series IDs between 0 and " + strconv.Itoa(churnIDStride))
+	}
+
+	ch := churn{
+		fraction: options.Fraction,
+		// the first series is always generated, even for an empty range
+		size:         max(maxSeriesID-minSeriesID, 1),
+		staleMarkers: options.StaleMarkers,
+	}
+
+	switch {
+	case options.Every != "" && options.EveryIterations != 0:
+		return churn{}, errors.New("churn expects either every or every_iterations, not both")
+	case options.EveryIterations > 0:
+		ch.generation = iteration / int64(options.EveryIterations)
+	case options.Every != "":
+		every, err := parseValueDuration(options.Every, 0)
+		if err != nil || every < time.Millisecond {
+			return churn{}, errors.New("the churn interval must be a duration of at least 1ms")
+		}
+
+		ch.generation = timestamp / every.Milliseconds()
+	default:
+		return churn{}, errors.New("churn expects a positive every interval or every_iterations")
+	}
+
+	return ch, nil
+}
+
+// churned reports whether the series of the range with the given ID is rotated.
+func (ch churn) churned(seriesID int) bool {
+	return ch.fraction > 0 && uniform(int64(seriesID)^churnSeed) < ch.fraction
+}
+
+// id returns the ID of the series of the current generation replacing the series seriesID of the range.
+func (ch churn) id(seriesID int) int {
+	return ch.idAt(seriesID, ch.generation)
+}
+
+func (ch churn) idAt(seriesID int, generation int64) int {
+	if !ch.churned(seriesID) {
+		return seriesID
+	}
+
+	return seriesID + int(generation%churnGenerations)*churnIDStride
+}
+
+// current returns the ID of the series seriesID of the range in the generation last recorded by rotate.
+// It must be called with values.mu held.
+func (ch churn) current(values *seriesValues, minSeriesID, seriesID int) int {
+	generation, ok := values.generations[[2]int{minSeriesID, ch.size}]
+	if !ok || seriesID < minSeriesID || seriesID >= minSeriesID+ch.size {
+		return seriesID
+	}
+
+	return ch.idAt(seriesID, generation)
+}
+
+// rotate records the generation of the range in values, and returns the IDs of the series retired
+// since the previous call for the same range. The state of the retired series is dropped.
+// It must be called with values.mu held.
+func (ch churn) rotate(values *seriesValues, minSeriesID int) []int {
+	if ch.fraction == 0 {
+		return nil
+	}
+
+	key := [2]int{minSeriesID, ch.size}
+
+	previous, seen := values.generations[key]
+	values.generations[key] = ch.generation
+
+	if !seen || previous == ch.generation {
+		return nil
+	}
+
+	var retired []int
+
+	for seriesID := minSeriesID; seriesID < minSeriesID+ch.size; seriesID++ {
+		if !ch.churned(seriesID) {
+			continue
+		}
+
+		id := ch.idAt(seriesID, previous)
+		delete(values.series, id)
+
+		retired = append(retired, id)
+	}
+
+	return retired
+}
+
+// staleNaN is the value of the staleness markers, a NaN that Prometheus tells apart from other NaNs.
+var staleNaN = math.Float64frombits(value.StaleNaN)
@@ -0,0 +1,184 @@
+package remotewrite
+
+import (
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// generateChurn generates the series of the range [0, 100) and returns their sample by name.
+func generateChurn(
+	t *testing.T, template *labelTemplates, timestamp int64, options TemplateOptions,
+) (map[string]float64, requestStats) {
+	t.Helper()
+
+	r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic test data
+
+	buf, stats, err := generateFromPrecompiledTemplates(r, 0, 100, timestamp, 0, 100, template, options)
+	require.NoError(t, err)
+
+	req := new(prompb.WriteRequest)
+	require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+
+	samples := make(map[string]float64, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		require.Len(t, ts.Samples, 1)
+		require.Equal(t, timestamp, ts.Samples[0].Timestamp)
+
+		samples[ts.Labels[0].Value] = ts.Samples[0].Value
+	}
+
+	require.Len(t, samples, stats.samples)
+
+	return samples, stats
+}
+
+func TestChurn(t *testing.T) {
+	t.Parallel()
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "metric_${series_id}"})
+	require.NoError(t, err)
+
+	options := TemplateOptions{Churn: ChurnOptions{Fraction: 0.3, Every: "1m", StaleMarkers: true}}
+
+	first, stats := generateChurn(t, template, 10*60000, options)
+	require.Equal(t, requestStats{series: 100, samples: 100}, stats)
+
+	// within the same interval, the same series are generated
+	again, stats := generateChurn(t, template, 10*60000+59999, options)
+	require.Equal(t, requestStats{series: 100, samples: 100}, stats)
+	require.Equal(t, slices.Sorted(maps.Keys(first)), slices.Sorted(maps.Keys(again)))
+
+	second, stats := generateChurn(t, template, 11*60000, options)
+
+	kept, retired, stale := 0, 0, 0
+
+	for name := range first {
+		v, ok := second[name]
+
+		switch {
+		case !ok:
+			retired++
+		case math.Float64bits(v) == value.StaleNaN:
+			stale++
+		default:
+			kept++
+		}
+	}
+
+	// the churned series of the first interval are replaced, with a staleness marker
+	require.Zero(t, retired)
+	require.Equal(t, 100, kept+stale)
+	require.InDelta(t, 30, stale, 15)
+	require.Equal(t, requestStats{series: 100 + stale, samples: 100 + stale}, stats)
+	require.Len(t, second, 100+stale)
+
+	for name, v := range second {
+		if _, ok := first[name]; !ok {
+			require.False(t, value.IsStaleNaN(v), name)
+		}
+	}
+
+	// the markers are only sent once
+	_, stats = generateChurn(t, template, 11*60000+1, options)
+	require.Equal(t, requestStats{series: 100, samples: 100}, stats)
+}
+
+func TestChurnEveryIterations(t *testing.T) {
+	t.Parallel()
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "metric_${series_id}"})
+	require.NoError(t, err)
+
+	options := TemplateOptions{Churn: ChurnOptions{Fraction: 1, EveryIterations: 2}}
+
+	first, _ := generateChurn(t, template, 1000, options)
+	require.Contains(t, first, "metric_0")
+
+	template.vars.iteration = 1
+	second, _ := generateChurn(t, template, 2000, options)
+	require.Equal(t, slices.Sorted(maps.Keys(first)), slices.Sorted(maps.Keys(second)))
+
+	// every series is replaced, without staleness markers
+	template.vars.iteration = 2
+	third, stats := generateChurn(t, template, 3000, options)
+	require.Equal(t, requestStats{series: 100, samples: 100}, stats)
+	require.Contains(t, third, "metric_"+strconv.Itoa(churnIDStride))
+	require.Contains(t, third, "metric_"+strconv.Itoa(churnIDStride+99))
+	require.NotContains(t, third, "metric_0")
+}
+
+func TestChurnAdjacentRanges(t *testing.T) {
+	t.Parallel()
+
+	options := TemplateOptions{Churn: ChurnOptions{Fraction: 0.5, EveryIterations: 1}}
+
+	// the ranges of two VUs, written concurrently
+	ranges := [][2]int{{0, 100}, {100, 200}}
+	templates := make([]*labelTemplates, len(ranges))
+
+	for i := range templates {
+		var err error
+
+		templates[i], err = compileLabelTemplates(map[string]string{"__name__": "metric_${series_id}"})
+		require.NoError(t, err)
+	}
+
+	// the VUs may not be at the same iteration, no series is ever written by both
+	owners := make(map[string]int)
+
+	for iteration := range int64(4) {
+		for i, template := range templates {
+			template.vars.iteration = iteration
+			r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic test data
+
+			buf, _, err := generateFromPrecompiledTemplates(
+				r, 0, 100, 1000*(iteration+1), ranges[i][0], ranges[i][1], template, options)
+			require.NoError(t, err)
+
+			req := new(prompb.WriteRequest)
+			require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+			require.Len(t, req.Timeseries, 100)
+
+			for _, ts := range req.Timeseries {
+				name := ts.Labels[0].Value
+
+				owner, ok := owners[name]
+				require.False(t, ok && owner != i, "%s is written by both ranges", name)
+
+				owners[name] = i
+			}
+		}
+	}
+}
+
+func TestChurnOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, options := range []ChurnOptions{
+		{Fraction: 2, Every: "1m"},
+		{Fraction: 0.5},
+		{Fraction: 0.5, Every: "1m", EveryIterations: 10},
+		{Fraction: 0.5, Every: "soon"},
+		{Fraction: 0.5, Every: "1us"},
+	} {
+		_, err := options.compile(0, 10, 1000, 0)
+		require.Error(t, err, options)
+	}
+
+	_, err := ChurnOptions{Fraction: 0.5, EveryIterations: 1}.compile(0, churnIDStride+1, 1000, 0)
+	require.Error(t, err)
+
+	ch, err := ChurnOptions{}.compile(0, 10, 1000, 0)
+	require.NoError(t, err)
+	require.Equal(t, 5, ch.id(5))
+}
@@ -0,0 +1,99 @@
+package remotewrite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	compressionSnappy = "snappy"
+	compressionZstd   = "zstd"
+	compressionGzip   = "gzip"
+	compressionNone   = "none"
+)
+
+// ErrUnsupportedCompression is returned when the configured compression codec is unknown.
+var ErrUnsupportedCompression = errors.New("compression must be one of \"snappy\", \"zstd\", \"gzip\" or \"none\"")
+
+// encoder compresses marshalled requests. Its state is reused across requests,
+// so that high request rates don't allocate a new encoder on every call. The compressed
+// requests get their own buffers, as the transport may still be sending a request body
+// after its response was received.
+type encoder struct {
+	// contentEncoding is the value of the Content-Encoding header, empty for uncompressed requests.
+	contentEncoding string
+	encode          func(src []byte) ([]byte, error)
+}
+
+func newEncoder(compression string) (*encoder, error) {
+	switch compression {
+	case "", compressionSnappy:
+		return &encoder{
+			contentEncoding: compressionSnappy,
+			encode: func(src []byte) ([]byte, error) {
+				return snappy.Encode(nil, src), nil
+			},
+		}, nil
+	case compressionZstd:
+		// EncodeAll is safe for concurrent use, a single encoder is shared by all requests.
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return &encoder{
+			contentEncoding: compressionZstd,
+			encode: func(src []byte) ([]byte, error) {
+				return enc.EncodeAll(src, nil), nil
+			},
+		}, nil
+	case compressionGzip:
+		writers := sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+		return &encoder{
+			contentEncoding: compressionGzip,
+			encode: func(src []byte) ([]byte, error) {
+				w, _ := writers.Get().(*gzip.Writer)
+				defer writers.Put(w)
+
+				buf := new(bytes.Buffer)
+				w.Reset(buf)
+
+				_, err := w.Write(src)
+				if err != nil {
+					return nil, err
+				}
+
+				err = w.Close()
+				if err != nil {
+					return nil, err
+				}
+
+				return buf.Bytes(), nil
+			},
+		}, nil
+	case compressionNone:
+		return &encoder{
+			encode: func(src []byte) ([]byte, error) {
+				return src, nil
+			},
+		}, nil
+	}
+
+	return nil, ErrUnsupportedCompression
+}
+
+// compress encodes data.
+func (e *encoder) compress(data []byte) ([]byte, error) {
+	compressed, err := e.encode(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compress remote-write request")
+	}
+
+	return compressed, nil
+}
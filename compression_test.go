@@ -0,0 +1,109 @@
+package remotewrite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoder(t *testing.T) {
+	t.Parallel()
+
+	zstdDecoder, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+
+	testcases := []struct {
+		compression     string
+		contentEncoding string
+		decode          func([]byte) ([]byte, error)
+	}{
+		{
+			compression:     "",
+			contentEncoding: "snappy",
+			decode:          func(b []byte) ([]byte, error) { return snappy.Decode(nil, b) },
+		},
+		{
+			compression:     "snappy",
+			contentEncoding: "snappy",
+			decode:          func(b []byte) ([]byte, error) { return snappy.Decode(nil, b) },
+		},
+		{
+			compression:     "zstd",
+			contentEncoding: "zstd",
+			decode:          func(b []byte) ([]byte, error) { return zstdDecoder.DecodeAll(b, nil) },
+		},
+		{
+			compression:     "gzip",
+			contentEncoding: "gzip",
+			decode: func(b []byte) ([]byte, error) {
+				r, err := gzip.NewReader(bytes.NewReader(b))
+				if err != nil {
+					return nil, err
+				}
+
+				return io.ReadAll(r)
+			},
+		},
+		{
+			compression:     "none",
+			contentEncoding: "",
+			decode:          func(b []byte) ([]byte, error) { return b, nil },
+		},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.compression, func(t *testing.T) {
+			t.Parallel()
+
+			enc, err := newEncoder(testcase.compression)
+			require.NoError(t, err)
+			require.Equal(t, testcase.contentEncoding, enc.contentEncoding)
+
+			data := bytes.Repeat([]byte("remote write "), 100)
+
+			compressed, err := enc.compress(data)
+			require.NoError(t, err)
+
+			// the body of a request isn't overwritten by the next one, the transport may still be sending it
+			_, err = enc.compress(bytes.Repeat([]byte("another request "), 100))
+			require.NoError(t, err)
+
+			decoded, err := testcase.decode(compressed)
+			require.NoError(t, err)
+			require.Equal(t, data, decoded)
+		})
+	}
+
+	_, err = newEncoder("lz4")
+	require.ErrorIs(t, err, ErrUnsupportedCompression)
+}
+
+func TestStoreCompression(t *testing.T) {
+	t.Parallel()
+
+	var contentEncoding string
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		contentEncoding = r.Header.Get("Content-Encoding")
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s", Compression: "zstd"},
+		vu:  s.vu,
+	}
+
+	res, err := c.Store([]Timeseries{{
+		Labels:  []Label{{Name: "__name__", Value: "metric"}},
+		Samples: []Sample{{Value: 42, Timestamp: 1000}},
+	}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, res.Status)
+	require.Equal(t, "zstd", contentEncoding)
+}
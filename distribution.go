@@ -0,0 +1,170 @@
+package remotewrite
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// distributionSeed is the fixed seed of the distribution functions, so that a series always gets
+// the same label values, and that their draws are independent from hash().
+const distributionSeed = 0x5eed_d157_5eed_d157
+
+// maxDistributionSize is the largest number of values of a zipf or weighted distribution.
+const maxDistributionSize = 1 << 20
+
+// uniform maps x to a pseudo-random number uniformly distributed in [0, 1).
+func uniform(x int64) float64 {
+	// #nosec G115 -- the value is only hashed
+	return float64(mix64(uint64(x)^distributionSeed)>>11) / (1 << 53)
+}
+
+// compileZipf compiles zipf(x, s, n), mapping x to a rank in [0, n) following a Zipf distribution
+// of exponent s: rank k is drawn with a probability proportional to 1/(k+1)^s, so a few ranks are
+// drawn for most values of x.
+func compileZipf(c *exprCompiler, args []*exprNode) (evalFunc, error) {
+	if len(args) != 3 { //nolint:mnd // x, s and n
+		return nil, errors.New("zipf expects 3 arguments: zipf(x, exponent, count)")
+	}
+
+	s, err := c.constantArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := c.constantArg(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	if s <= 0 || n < 1 || n > maxDistributionSize || n != math.Trunc(n) {
+		return nil, fmt.Errorf("zipf expects a positive exponent and an integer count from 1 to %d", maxDistributionSize)
+	}
+
+	weights := make([]float64, int(n))
+	for k := range weights {
+		weights[k] = math.Pow(float64(k+1), -s)
+	}
+
+	return c.compileDistribution(args[0], weights)
+}
+
+// compileWeighted compiles weighted(x, w0, w1, ...), mapping x to an index i drawn with a probability
+// proportional to the weight wi. It's meant to be followed by a list of values, as in
+// weighted(series_id, 90, 9, 1)%[200,404,500].
+func compileWeighted(c *exprCompiler, args []*exprNode) (evalFunc, error) {
+	if len(args) < 2 { //nolint:mnd // x and at least one weight
+		return nil, errors.New("weighted expects at least 2 arguments: weighted(x, weight0, weight1, ...)")
+	}
+
+	if len(args)-1 > maxDistributionSize {
+		return nil, fmt.Errorf("weighted accepts up to %d weights", maxDistributionSize)
+	}
+
+	weights := make([]float64, len(args)-1)
+	for i, arg := range args[1:] {
+		w, err := c.constantArg(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if w < 0 {
+			return nil, errors.New("weights can't be negative")
+		}
+
+		weights[i] = w
+	}
+
+	return c.compileDistribution(args[0], weights)
+}
+
+// compileDistribution compiles the draw of an index with probabilities proportional to weights,
+// from the uniform mapping of x, through the inverse of the cumulative distribution.
+func (c *exprCompiler) compileDistribution(x *exprNode, weights []float64) (evalFunc, error) {
+	eval, err := c.compileExpr(x)
+	if err != nil {
+		return nil, err
+	}
+
+	cdf := make([]float64, len(weights))
+	total := 0.0
+
+	for i, w := range weights {
+		total += w
+		cdf[i] = total
+	}
+
+	if total <= 0 {
+		return nil, errors.New("the sum of the weights must be positive")
+	}
+
+	for i := range cdf {
+		cdf[i] /= total
+	}
+
+	cdf[len(cdf)-1] = 1
+
+	return func(seriesID int) int64 {
+		u := uniform(eval(seriesID))
+
+		// binary search of the first index whose cumulative probability is above u
+		lo, hi := 0, len(cdf)-1
+		for lo < hi {
+			mid := int(uint(lo+hi) >> 1) // #nosec G115 -- indexes are non negative
+			if cdf[mid] > u {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+
+		return int64(lo)
+	}, nil
+}
+
+// compileExponential compiles exponential(x, mean), mapping x to a non negative integer following
+// an exponential distribution of the given mean: small values are drawn most of the time, with
+// a long tail of larger ones.
+func compileExponential(c *exprCompiler, args []*exprNode) (evalFunc, error) {
+	if len(args) != 2 { //nolint:mnd // x and mean
+		return nil, errors.New("exponential expects 2 arguments: exponential(x, mean)")
+	}
+
+	mean, err := c.constantArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if mean <= 0 {
+		return nil, errors.New("exponential expects a positive mean")
+	}
+
+	eval, err := c.compileExpr(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return func(seriesID int) int64 {
+		return int64(-mean * math.Log1p(-uniform(eval(seriesID))))
+	}, nil
+}
+
+// constantArg returns the value of a constant parameter of a function, either an integer expression
+// or a decimal number.
+func (c *exprCompiler) constantArg(node *exprNode) (float64, error) {
+	if node.kind == exprDecimal {
+		return node.decimal, nil
+	}
+
+	if !isConstant(node) {
+		return 0, errors.New("the parameters of distributions must be constant")
+	}
+
+	eval, err := c.compileExpr(node)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(eval(0)), nil
+}
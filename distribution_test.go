@@ -0,0 +1,118 @@
+package remotewrite
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateDistributions(t *testing.T) {
+	t.Parallel()
+
+	const series = 100000
+
+	frequencies := func(t *testing.T, template string) map[int]float64 {
+		t.Helper()
+
+		compiled, err := compileTemplate(template)
+		require.NoError(t, err)
+
+		counts := make(map[int]float64)
+
+		for seriesID := range series {
+			value, err := strconv.Atoi(string(compiled.AppendByte(nil, seriesID)))
+			require.NoError(t, err)
+
+			counts[value] += 1.0 / series
+		}
+
+		return counts
+	}
+
+	t.Run("zipf", func(t *testing.T) {
+		t.Parallel()
+
+		f := frequencies(t, "${zipf(series_id, 1, 10)}")
+		require.Len(t, f, 10)
+
+		// with an exponent of 1, the frequency of rank k is 1/(k+1) of the first one's, 1/H(10)
+		require.InDelta(t, 0.341, f[0], 0.01)
+		require.InDelta(t, 0.341/2, f[1], 0.01)
+		require.InDelta(t, 0.341/10, f[9], 0.01)
+	})
+
+	t.Run("zipf decimal exponent", func(t *testing.T) {
+		t.Parallel()
+
+		f := frequencies(t, "${zipf(series_id, 1.5, 1000)}")
+		require.Greater(t, f[0], f[1])
+		require.Greater(t, f[1], f[10])
+		require.Greater(t, f[10], f[100])
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		t.Parallel()
+
+		f := frequencies(t, "${weighted(series_id, 90, 9, 0, 1)}")
+		require.InDelta(t, 0.9, f[0], 0.01)
+		require.InDelta(t, 0.09, f[1], 0.01)
+		require.Zero(t, f[2])
+		require.InDelta(t, 0.01, f[3], 0.005)
+	})
+
+	t.Run("exponential", func(t *testing.T) {
+		t.Parallel()
+
+		mean := 0.0
+		for value, f := range frequencies(t, "${exponential(series_id, 10)}") {
+			require.GreaterOrEqual(t, value, 0)
+
+			mean += float64(value) * f
+		}
+
+		// the values are truncated, which lowers the mean by about 0.5
+		require.InDelta(t, 9.5, mean, 0.3)
+	})
+}
+
+func TestTemplateDistributionsStable(t *testing.T) {
+	t.Parallel()
+
+	first, err := compileTemplate("${weighted(series_id/10, 9, 1)%[hot,cold]}-${zipf(series_id, 1.1, 100)}")
+	require.NoError(t, err)
+
+	second, err := compileTemplate("${weighted(series_id/10, 9, 1)%[hot,cold]}-${zipf(series_id, 1.1, 100)}")
+	require.NoError(t, err)
+
+	for seriesID := range 1000 {
+		require.Equal(t, string(first.AppendByte(nil, seriesID)), string(second.AppendByte(nil, seriesID)))
+	}
+}
+
+func TestTemplateDistributionsErrors(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		template      string
+		expectedError string
+	}{
+		{template: "${zipf(series_id, 1)}", expectedError: "zipf expects 3 arguments"},
+		{template: "${zipf(series_id, 0, 10)}", expectedError: "positive exponent"},
+		{template: "${zipf(series_id, 1, 2.5)}", expectedError: "integer count"},
+		{template: "${zipf(series_id, series_id, 10)}", expectedError: "must be constant"},
+		{template: "${weighted(series_id)}", expectedError: "at least 2 arguments"},
+		{template: "${weighted(series_id, 0, 0)}", expectedError: "must be positive"},
+		{template: "${weighted(series_id, -1, 2)}", expectedError: "can't be negative"},
+		{template: "${exponential(series_id, 0)}", expectedError: "positive mean"},
+		{template: "${series_id*1.5}", expectedError: "parameters of functions"},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.template, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := compileTemplate(testcase.template)
+			require.ErrorContains(t, err, testcase.expectedError)
+		})
+	}
+}
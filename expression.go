@@ -0,0 +1,733 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.k6.io/k6/v2/lib"
+)
+
+// errUnsupportedTemplate is wrapped by all the errors of label templates parsing.
+var errUnsupportedTemplate = errors.New("unsupported template")
+
+const (
+	// maxTemplateWidth is the maximum zero-padding width of a placeholder.
+	maxTemplateWidth = 32
+	// maxPossibleValues is the largest modulo whose values are formatted ahead of time.
+	maxPossibleValues = 1 << 16
+)
+
+const templateZeros = "00000000000000000000000000000000"
+
+// evalFunc evaluates a template expression for a series.
+type evalFunc func(seriesID int) int64
+
+// templateVars are the values of the template variables other than series_id, resolved for every request.
+type templateVars struct {
+	vu         int64
+	iteration  int64
+	timeBucket int64
+	scenario   string
+	instance   string
+}
+
+// instanceName is the name of the k6 instance, its host name.
+var instanceName = sync.OnceValue(func() string {
+	name, _ := os.Hostname()
+
+	return name
+})
+
+// newTemplateVars resolves the template variables from the VU's state and context.
+func newTemplateVars(ctx context.Context, state *lib.State) templateVars {
+	vars := templateVars{
+		vu:         int64(state.VUIDGlobal), // #nosec G115 -- VU IDs are far below the int64 limit
+		iteration:  state.Iteration,
+		timeBucket: time.Now().Unix(),
+		instance:   instanceName(),
+	}
+
+	if scenario := lib.GetScenarioState(ctx); scenario != nil {
+		vars.scenario = scenario.Name
+	}
+
+	return vars
+}
+
+// templateVariables are the variables of template expressions, string variables can only be used alone.
+var templateVariables = map[string]struct {
+	number func(seriesID int, vars *templateVars) int64
+	str    func(vars *templateVars) string
+}{
+	"series_id":   {number: func(seriesID int, _ *templateVars) int64 { return int64(seriesID) }},
+	"vu":          {number: func(_ int, vars *templateVars) int64 { return vars.vu }},
+	"iteration":   {number: func(_ int, vars *templateVars) int64 { return vars.iteration }},
+	"time_bucket": {number: func(_ int, vars *templateVars) int64 { return vars.timeBucket }},
+	"scenario":    {str: func(vars *templateVars) string { return vars.scenario }},
+	"instance":    {str: func(vars *templateVars) string { return vars.instance }},
+}
+
+// exprKind is the kind of a node of a template expression.
+type exprKind int
+
+const (
+	exprNumber exprKind = iota
+	exprVariable
+	exprBinary
+	exprNegate
+	exprCall
+	exprChoice
+	exprDecimal
+)
+
+// exprNode is a node of the syntax tree of a template expression.
+type exprNode struct {
+	kind  exprKind
+	value int64
+	// decimal is the value of a decimal number, only allowed as a function parameter.
+	decimal float64
+	// name is the name of a variable or function.
+	name string
+	// op is the operator of a binary node.
+	op   byte
+	args []*exprNode
+	// choices are the values a choice node picks from.
+	choices []string
+}
+
+// exprCompiler compiles template expressions into closures reading the variables from vars.
+type exprCompiler struct {
+	vars *templateVars
+}
+
+// templateFunctions are the functions available in template expressions, compiled from their arguments.
+// They are registered by init, as compiling their arguments refers back to them.
+var templateFunctions map[string]func(c *exprCompiler, args []*exprNode) (evalFunc, error)
+
+func init() {
+	templateFunctions = map[string]func(c *exprCompiler, args []*exprNode) (evalFunc, error){
+		"hash":        compileHash,
+		"zipf":        compileZipf,
+		"exponential": compileExponential,
+		"weighted":    compileWeighted,
+	}
+}
+
+// placeholderFormat is the format of the value of a placeholder, given after a colon, e.g. ${series_id:06x}.
+type placeholderFormat struct {
+	base  int
+	width int
+}
+
+// compilePlaceholder compiles the ${...} placeholder at the start of template, preceded by the literal
+// prefix, returning the length of template it consumed. The literal after the last placeholder of
+// the template is consumed along with it, so that the literals are memoized with the values of the
+// placeholders instead of being appended on their own for every series.
+//
+// A placeholder holds an integer expression of series_id and the other variables, made of integers,
+// + - * / % operators, parentheses and functions like hash(series_id), optionally followed by
+// a format: a zero-padding width, like in ${series_id:06}, and/or x for hexadecimal.
+// String variables, like ${scenario}, are only allowed alone, as are lists of values picked by
+// the modulo of an expression, like ${series_id%[GET,POST]}.
+func compilePlaceholder(prefix, template string, vars *templateVars) (func([]byte, int) []byte, int, error) {
+	if !strings.Contains(template, "}") {
+		return nil, 0, fmt.Errorf("%w: no closing bracket in template", errUnsupportedTemplate)
+	}
+
+	p := &exprParser{input: template, pos: len("${")}
+
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w %q: %w", errUnsupportedTemplate, template, err)
+	}
+
+	format, err := p.parseFormat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w %q: %w", errUnsupportedTemplate, template, err)
+	}
+
+	suffix := ""
+	if rest := template[p.pos:]; !strings.Contains(rest, "${") {
+		suffix = rest
+	}
+
+	c := &exprCompiler{vars: vars}
+
+	part, err := c.compileFormatted(node, format, prefix, suffix)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w %q: %w", errUnsupportedTemplate, template, err)
+	}
+
+	return part, p.pos + len(suffix), nil
+}
+
+// compileFormatted compiles an expression into an appender of its formatted value, between the literals
+// prefix and suffix.
+func (c *exprCompiler) compileFormatted(
+	node *exprNode, format placeholderFormat, prefix, suffix string,
+) (func([]byte, int) []byte, error) {
+	if node.kind == exprVariable && templateVariables[node.name].str != nil {
+		if format != (placeholderFormat{base: 10}) {
+			return nil, fmt.Errorf("%q is a string and can't be formatted", node.name)
+		}
+
+		str, vars := templateVariables[node.name].str, c.vars
+
+		return func(b []byte, _ int) []byte {
+			b = append(b, prefix...)
+			b = append(b, str(vars)...)
+
+			return append(b, suffix...)
+		}, nil
+	}
+
+	if node.kind == exprChoice {
+		if format != (placeholderFormat{base: 10}) {
+			return nil, errors.New("a list of values can't be formatted")
+		}
+
+		return c.compileChoice(node, prefix, suffix)
+	}
+
+	eval, err := c.compileExpr(node)
+	if err != nil {
+		return nil, err
+	}
+
+	appendFormatted := func(b []byte, value int64) []byte {
+		b = append(b, prefix...)
+		b = format.append(b, value)
+
+		return append(b, suffix...)
+	}
+
+	// The series_id itself changes for every series, there is nothing to memoize.
+	if isSeriesID(node) {
+		return func(b []byte, seriesID int) []byte {
+			return appendFormatted(b, int64(seriesID))
+		}, nil
+	}
+
+	// The values of a modulo by a constant are all formatted ahead of time.
+	if node.kind == exprBinary && node.op == '%' &&
+		node.args[1].kind == exprNumber && node.args[1].value <= maxPossibleValues {
+		d := node.args[1].value
+
+		possibleValues := make([][]byte, d)
+		for j := range d {
+			possibleValues[j] = appendFormatted(nil, j)
+		}
+
+		var memoize []byte
+
+		return func(b []byte, seriesID int) []byte {
+			value := eval(seriesID)
+			if value >= 0 {
+				return append(b, possibleValues[value]...)
+			}
+
+			memoize = appendFormatted(memoize[:0], value)
+
+			return append(b, memoize...)
+		}, nil
+	}
+
+	var memoize []byte
+
+	var memoizeValue int64
+
+	// series_id / constant is divided inline, it's the hot loop of the templates of large cardinalities
+	if node.kind == exprBinary && node.op == '/' && isSeriesID(node.args[0]) && node.args[1].kind == exprNumber {
+		d := node.args[1].value
+
+		return func(b []byte, seriesID int) []byte {
+			value := int64(seriesID) / d
+			if memoize == nil || value != memoizeValue {
+				memoizeValue = value
+				memoize = appendFormatted(memoize[:0], value)
+			}
+
+			return append(b, memoize...)
+		}, nil
+	}
+
+	return func(b []byte, seriesID int) []byte {
+		value := eval(seriesID)
+		if memoize == nil || value != memoizeValue {
+			memoizeValue = value
+			memoize = appendFormatted(memoize[:0], value)
+		}
+
+		return append(b, memoize...)
+	}, nil
+}
+
+// append appends the formatted value to b, padding it with zeros after its sign.
+func (f placeholderFormat) append(b []byte, value int64) []byte {
+	start := len(b)
+	b = strconv.AppendInt(b, value, f.base)
+
+	pad := f.width - (len(b) - start)
+	if pad <= 0 {
+		return b
+	}
+
+	if value < 0 {
+		start++
+	}
+
+	b = append(b, templateZeros[:pad]...)
+	copy(b[start+pad:], b[start:len(b)-pad])
+	copy(b[start:start+pad], templateZeros)
+
+	return b
+}
+
+// compileExpr compiles an expression into a closure, evaluating constant sub-expressions once.
+func (c *exprCompiler) compileExpr(node *exprNode) (evalFunc, error) {
+	eval, err := c.compileNode(node)
+	if err != nil || node.kind == exprNumber || !isConstant(node) {
+		return eval, err
+	}
+
+	value := eval(0)
+
+	return func(int) int64 { return value }, nil
+}
+
+// isConstant reports whether the expression doesn't depend on any variable.
+func isConstant(node *exprNode) bool {
+	if node.kind == exprVariable {
+		return false
+	}
+
+	for _, arg := range node.args {
+		if !isConstant(arg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSeriesID reports whether the expression is the series_id variable.
+func isSeriesID(node *exprNode) bool {
+	return node.kind == exprVariable && node.name == "series_id"
+}
+
+func (c *exprCompiler) compileNode(node *exprNode) (evalFunc, error) {
+	switch node.kind {
+	case exprNumber:
+		value := node.value
+
+		return func(int) int64 { return value }, nil
+	case exprVariable:
+		if node.name == "series_id" {
+			return func(seriesID int) int64 { return int64(seriesID) }, nil
+		}
+
+		number, vars := templateVariables[node.name].number, c.vars
+		if number == nil {
+			return nil, fmt.Errorf("%q is a string and can't be used in an expression", node.name)
+		}
+
+		return func(seriesID int) int64 { return number(seriesID, vars) }, nil
+	case exprNegate:
+		arg, err := c.compileExpr(node.args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return func(seriesID int) int64 { return -arg(seriesID) }, nil
+	case exprCall:
+		return templateFunctions[node.name](c, node.args)
+	case exprBinary:
+		return c.compileBinary(node)
+	case exprChoice:
+		return nil, errors.New("a list of values can only be used alone")
+	case exprDecimal:
+		return nil, errors.New("decimal numbers can only be parameters of functions")
+	}
+
+	return nil, fmt.Errorf("unknown expression %v", node.kind)
+}
+
+func (c *exprCompiler) compileBinary(node *exprNode) (evalFunc, error) {
+	left, err := c.compileExpr(node.args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := c.compileExpr(node.args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if (node.op == '/' || node.op == '%') && node.args[1].kind == exprNumber && node.args[1].value <= 0 {
+		return nil, errors.New("the divisor must be a positive integer")
+	}
+
+	if isSeriesID(node.args[0]) && node.args[1].kind == exprNumber {
+		return compileSeriesIDBinary(node.op, node.args[1].value)
+	}
+
+	switch node.op {
+	case '+':
+		return func(seriesID int) int64 { return left(seriesID) + right(seriesID) }, nil
+	case '-':
+		return func(seriesID int) int64 { return left(seriesID) - right(seriesID) }, nil
+	case '*':
+		return func(seriesID int) int64 { return left(seriesID) * right(seriesID) }, nil
+	case '/':
+		return func(seriesID int) int64 {
+			d := right(seriesID)
+			if d == 0 {
+				return 0
+			}
+
+			return left(seriesID) / d
+		}, nil
+	case '%':
+		return func(seriesID int) int64 {
+			d := right(seriesID)
+			if d == 0 {
+				return 0
+			}
+
+			return left(seriesID) % d
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %q", node.op)
+}
+
+// compileSeriesIDBinary compiles series_id <op> constant, the most common expression of the templates,
+// without calling closures for its operands. The divisor is positive.
+func compileSeriesIDBinary(op byte, value int64) (evalFunc, error) {
+	switch op {
+	case '+':
+		return func(seriesID int) int64 { return int64(seriesID) + value }, nil
+	case '-':
+		return func(seriesID int) int64 { return int64(seriesID) - value }, nil
+	case '*':
+		return func(seriesID int) int64 { return int64(seriesID) * value }, nil
+	case '/':
+		return func(seriesID int) int64 { return int64(seriesID) / value }, nil
+	case '%':
+		return func(seriesID int) int64 { return int64(seriesID) % value }, nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+// compileChoice compiles a list of values, picked by the modulo of the expression by their count.
+// Like the possible values of a modulo, they are all expanded ahead of time, between prefix and suffix.
+func (c *exprCompiler) compileChoice(node *exprNode, prefix, suffix string) (func([]byte, int) []byte, error) {
+	eval, err := c.compileExpr(node.args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	possibleValues := make([][]byte, len(node.choices))
+	for i, choice := range node.choices {
+		possibleValues[i] = []byte(prefix + choice + suffix)
+	}
+
+	n := int64(len(possibleValues))
+
+	return func(b []byte, seriesID int) []byte {
+		i := eval(seriesID) % n
+		if i < 0 {
+			i += n
+		}
+
+		return append(b, possibleValues[i]...)
+	}, nil
+}
+
+func compileHash(c *exprCompiler, args []*exprNode) (evalFunc, error) {
+	if len(args) != 1 {
+		return nil, errors.New("hash expects a single argument")
+	}
+
+	arg, err := c.compileExpr(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return func(seriesID int) int64 {
+		// #nosec G115 -- the value is only hashed, and the result is kept positive
+		return int64(mix64(uint64(arg(seriesID))) >> 1)
+	}, nil
+}
+
+// exprParser is a recursive descent parser of template expressions:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/" | "%") factor | "%" "[" value { "," value } "]" }
+//	factor = integer | decimal | variable | function "(" expr { "," expr } ")" | "(" expr ")" | "-" factor
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// peek returns the next non space character, 0 at the end of the input.
+func (p *exprParser) peek() byte {
+	p.skipSpaces()
+
+	if p.pos == len(p.input) {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *exprParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.unexpected()
+	}
+
+	p.pos++
+
+	return nil
+}
+
+func (p *exprParser) unexpected() error {
+	if p.pos == len(p.input) {
+		return errors.New("unexpected end of template")
+	}
+
+	return fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+}
+
+func (p *exprParser) parseExpr() (*exprNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.pos++
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &exprNode{kind: exprBinary, op: c, args: []*exprNode{left, right}}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseTerm() (*exprNode, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	for c := p.peek(); c == '*' || c == '/' || c == '%'; c = p.peek() {
+		p.pos++
+
+		if c == '%' && p.peek() == '[' {
+			choices, err := p.parseChoices()
+			if err != nil {
+				return nil, err
+			}
+
+			left = &exprNode{kind: exprChoice, args: []*exprNode{left}, choices: choices}
+
+			continue
+		}
+
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &exprNode{kind: exprBinary, op: c, args: []*exprNode{left, right}}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseFactor() (*exprNode, error) {
+	c := p.peek()
+
+	switch {
+	case c == '(':
+		p.pos++
+
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		return node, p.expect(')')
+	case c == '-':
+		p.pos++
+
+		node, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+
+		return &exprNode{kind: exprNegate, args: []*exprNode{node}}, nil
+	case isDigit(c):
+		start := p.pos
+		for p.pos < len(p.input) && isDigit(p.input[p.pos]) {
+			p.pos++
+		}
+
+		if p.pos+1 < len(p.input) && p.input[p.pos] == '.' && isDigit(p.input[p.pos+1]) {
+			p.pos++
+			for p.pos < len(p.input) && isDigit(p.input[p.pos]) {
+				p.pos++
+			}
+
+			decimal, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+			if err != nil {
+				return nil, err
+			}
+
+			return &exprNode{kind: exprDecimal, decimal: decimal}, nil
+		}
+
+		value, err := strconv.ParseInt(p.input[start:p.pos], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return &exprNode{kind: exprNumber, value: value}, nil
+	case isLetter(c):
+		return p.parseIdentifier()
+	}
+
+	return nil, p.unexpected()
+}
+
+func (p *exprParser) parseIdentifier() (*exprNode, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (isLetter(p.input[p.pos]) || isDigit(p.input[p.pos])) {
+		p.pos++
+	}
+
+	name := p.input[start:p.pos]
+
+	if _, ok := templateFunctions[name]; ok {
+		args, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+
+		return &exprNode{kind: exprCall, name: name, args: args}, nil
+	}
+
+	if _, ok := templateVariables[name]; !ok {
+		return nil, fmt.Errorf("unknown variable %q", name)
+	}
+
+	return &exprNode{kind: exprVariable, name: name}, nil
+}
+
+func (p *exprParser) parseArguments() ([]*exprNode, error) {
+	err := p.expect('(')
+	if err != nil {
+		return nil, err
+	}
+
+	var args []*exprNode
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+
+		if p.peek() != ',' {
+			break
+		}
+
+		p.pos++
+	}
+
+	return args, p.expect(')')
+}
+
+// parseChoices parses a list of comma separated values in square brackets. The values are trimmed
+// of spaces and can't contain commas, square or curly brackets.
+func (p *exprParser) parseChoices() ([]string, error) {
+	p.pos++ // [
+
+	var choices []string
+
+	for {
+		end := strings.IndexAny(p.input[p.pos:], ",]}")
+		if end == -1 || p.input[p.pos+end] == '}' {
+			return nil, errors.New("no closing square bracket in list of values")
+		}
+
+		choices = append(choices, strings.TrimSpace(p.input[p.pos:p.pos+end]))
+		p.pos += end + 1
+
+		if p.input[p.pos-1] == ']' {
+			return choices, nil
+		}
+	}
+}
+
+// parseFormat parses the optional format of the placeholder, and its closing bracket.
+func (p *exprParser) parseFormat() (placeholderFormat, error) {
+	//nolint:mnd // 10 is the base for decimal string conversion
+	format := placeholderFormat{base: 10}
+
+	if p.peek() == ':' {
+		p.pos++
+
+		start := p.pos
+		for p.pos < len(p.input) && isDigit(p.input[p.pos]) {
+			p.pos++
+		}
+
+		if p.pos > start {
+			if p.input[start] != '0' {
+				return format, errors.New("the width of a placeholder must start with 0, e.g. :06")
+			}
+
+			format.width, _ = strconv.Atoi(p.input[start:p.pos])
+			if format.width > maxTemplateWidth {
+				return format, fmt.Errorf("the width of a placeholder can't exceed %d", maxTemplateWidth)
+			}
+		}
+
+		if p.pos < len(p.input) && p.input[p.pos] == 'x' {
+			//nolint:mnd // 16 is the base for hexadecimal string conversion
+			format.base = 16
+			p.pos++
+		}
+	}
+
+	return format, p.expect('}')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
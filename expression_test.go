@@ -0,0 +1,111 @@
+package remotewrite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/v2/lib"
+)
+
+func TestTemplateVariables(t *testing.T) {
+	t.Parallel()
+
+	vars := templateVars{
+		vu:         3,
+		iteration:  12,
+		timeBucket: 7200,
+		scenario:   "ingest",
+		instance:   "k6-0",
+	}
+
+	testcases := []struct {
+		template      string
+		result        string
+		expectedError string
+	}{
+		{template: "vu-${vu}-${series_id}", result: "vu-3-5"},
+		{template: "${vu*1000+series_id}", result: "3005"},
+		{template: "${iteration%10}", result: "2"},
+		{template: "${time_bucket/3600}", result: "2"},
+		{template: "${scenario}-${instance}", result: "ingest-k6-0"},
+		{template: "${hash(vu):x}", result: "f29af76f18a1478"},
+		{template: "${scenario/2}", expectedError: "can't be used in an expression"},
+		{template: "${instance:04}", expectedError: "can't be formatted"},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.template, func(t *testing.T) {
+			t.Parallel()
+
+			compiled, err := compileTemplate(testcase.template)
+			if testcase.expectedError != "" {
+				require.ErrorContains(t, err, testcase.expectedError)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			*compiled.vars = vars
+			require.Equal(t, testcase.result, string(compiled.AppendByte(nil, 5)))
+
+			// the variables are read every time the value is generated
+			*compiled.vars = templateVars{}
+			require.NotEqual(t, testcase.result, string(compiled.AppendByte(nil, 5)))
+		})
+	}
+}
+
+func TestNewTemplateVars(t *testing.T) {
+	t.Parallel()
+
+	ctx := lib.WithScenarioState(context.Background(), &lib.ScenarioState{Name: "ingest"})
+
+	vars := newTemplateVars(ctx, &lib.State{VUIDGlobal: 4, Iteration: 9})
+	require.Equal(t, int64(4), vars.vu)
+	require.Equal(t, int64(9), vars.iteration)
+	require.Equal(t, "ingest", vars.scenario)
+	require.Equal(t, instanceName(), vars.instance)
+	require.InDelta(t, time.Now().Unix(), vars.timeBucket, 1)
+
+	vars = newTemplateVars(context.Background(), &lib.State{})
+	require.Empty(t, vars.scenario)
+}
+
+func TestTemplateChoices(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		template      string
+		results       []string
+		expectedError string
+	}{
+		{template: "${series_id%[GET,POST,PUT,DELETE]}", results: []string{"GET", "POST", "PUT", "DELETE", "GET"}},
+		{template: "${(series_id/2)%[us-east-1, eu-west-1]}", results: []string{"us-east-1", "us-east-1", "eu-west-1"}},
+		{template: "status=${series_id%[200,404,500]};", results: []string{"status=200;", "status=404;", "status=500;"}},
+		{template: "${(series_id-2)%[a,b,c]}", results: []string{"b", "c", "a"}},
+		{template: "${series_id%[only]}", results: []string{"only", "only"}},
+		{template: "${series_id-1%[a,b]}", expectedError: "can only be used alone"},
+		{template: "${series_id%[a,b}", expectedError: "no closing square bracket"},
+		{template: "${series_id%[a,b]:04}", expectedError: "can't be formatted"},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.template, func(t *testing.T) {
+			t.Parallel()
+
+			compiled, err := compileTemplate(testcase.template)
+			if testcase.expectedError != "" {
+				require.ErrorContains(t, err, testcase.expectedError)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			for seriesID, result := range testcase.results {
+				require.Equal(t, result, string(compiled.AppendByte(nil, seriesID)))
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package remotewrite
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Histogram represents a Prometheus native histogram sample.
+//
+// Buckets are described with spans and either deltas (integer histograms) or absolute
+// counts (float histograms). A histogram that has any PositiveCounts or NegativeCounts
+// is encoded as a float histogram, otherwise it is encoded as an integer histogram.
+type Histogram struct {
+	Count         float64
+	Sum           float64
+	Schema        int32
+	ZeroThreshold float64
+	ZeroCount     float64
+
+	PositiveSpans  []BucketSpan
+	PositiveDeltas []int64
+	PositiveCounts []float64
+	NegativeSpans  []BucketSpan
+	NegativeDeltas []int64
+	NegativeCounts []float64
+
+	Timestamp int64
+}
+
+// BucketSpan defines a number of consecutive buckets in a native histogram.
+type BucketSpan struct {
+	Offset int32
+	Length uint32
+}
+
+// IsFloat reports whether the histogram carries float bucket counts.
+func (h Histogram) IsFloat() bool {
+	return len(h.PositiveCounts) > 0 || len(h.NegativeCounts) > 0
+}
+
+func (h Histogram) toPrompb() prompb.Histogram {
+	if h.Timestamp == 0 {
+		h.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	ph := prompb.Histogram{
+		Sum:            h.Sum,
+		Schema:         h.Schema,
+		ZeroThreshold:  h.ZeroThreshold,
+		PositiveSpans:  toPrompbSpans(h.PositiveSpans),
+		PositiveDeltas: h.PositiveDeltas,
+		PositiveCounts: h.PositiveCounts,
+		NegativeSpans:  toPrompbSpans(h.NegativeSpans),
+		NegativeDeltas: h.NegativeDeltas,
+		NegativeCounts: h.NegativeCounts,
+		Timestamp:      h.Timestamp,
+	}
+
+	if h.IsFloat() {
+		ph.Count = &prompb.Histogram_CountFloat{CountFloat: h.Count}
+		ph.ZeroCount = &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: h.ZeroCount}
+	} else {
+		ph.Count = &prompb.Histogram_CountInt{CountInt: uint64(h.Count)}
+		ph.ZeroCount = &prompb.Histogram_ZeroCountInt{ZeroCountInt: uint64(h.ZeroCount)}
+	}
+
+	return ph
+}
+
+func toPrompbSpans(spans []BucketSpan) []prompb.BucketSpan {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	res := make([]prompb.BucketSpan, 0, len(spans))
+	for _, s := range spans {
+		res = append(res, prompb.BucketSpan{Offset: s.Offset, Length: s.Length})
+	}
+
+	return res
+}
+
+// HistogramTemplateOptions configures the native histograms generated for each templated series.
+type HistogramTemplateOptions struct {
+	// Schema is the resolution of the exponential buckets, from -4 to 8.
+	Schema int32
+	// Observations is the number of observations recorded in each histogram, 100 if not set.
+	Observations int
+	// ZeroThreshold is the width of the zero bucket.
+	ZeroThreshold float64
+	// Seed overrides the client's seed for this call.
+	Seed int64
+}
+
+const defaultHistogramObservations = 100
+
+// StoreHistogramsFromPrecompiledTemplates generates and stores native histograms using precompiled
+// label templates. Every series gets a histogram of observations drawn between minValue and maxValue.
+func (c *Client) StoreHistogramsFromPrecompiledTemplates(
+	minValue, maxValue int,
+	timestamp int64, minSeriesID, maxSeriesID int,
+	template *labelTemplates,
+	options HistogramTemplateOptions,
+) (Response, error) {
+	state := c.vu.State()
+	if state == nil {
+		return newResponse(), errors.New("State is nil")
+	}
+
+	if options.Schema < -4 || options.Schema > 8 {
+		return newResponse(), errors.New("histogram schema must be between -4 and 8")
+	}
+
+	start := time.Now()
+	r := c.newRand(state, options.Seed, minSeriesID)
+
+	template.setVars(newTemplateVars(c.vu.Context(), state))
+
+	buf, err := generateHistogramsFromPrecompiledTemplates(
+		r, minValue, maxValue, timestamp, minSeriesID, maxSeriesID, template, options,
+	)
+	if err != nil {
+		return newResponse(), err
+	}
+
+	series := max(maxSeriesID-minSeriesID, 0)
+
+	return c.sendGenerated(state, buf, requestStats{series: series, histograms: series, start: start})
+}
+
+func generateHistogramsFromPrecompiledTemplates(
+	r *rand.Rand,
+	minValue, maxValue int,
+	timestamp int64, minSeriesID, maxSeriesID int,
+	template *labelTemplates,
+	options HistogramTemplateOptions,
+) (*bytes.Buffer, error) {
+	if options.Observations == 0 {
+		options.Observations = defaultHistogramObservations
+	}
+
+	//nolint:mnd // 1024 bytes is a reasonable initial buffer size
+	bigB := make([]byte, 1024)
+	buf := new(bytes.Buffer)
+	tsBuf := new(bytes.Buffer)
+
+	for seriesID := minSeriesID; seriesID < maxSeriesID; seriesID++ {
+		tsBuf.Reset()
+		template.writeLabels(tsBuf, seriesID)
+
+		h := generateHistogram(r, minValue, maxValue, timestamp, options)
+
+		data, err := proto.Marshal(protoadapt.MessageV2Of(&h))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal histogram")
+		}
+
+		//nolint:mnd // 0x22 is the protobuf tag of the TimeSeries histograms field
+		bigB[0] = 0x22
+		bigB = protowire.AppendVarint(bigB[:1], uint64(len(data))) // #nosec G115 -- len() result is always non-negative
+		tsBuf.Write(bigB)
+		tsBuf.Write(data)
+
+		bigB[0] = 0xa
+		bigB = protowire.AppendVarint(bigB[:1], uint64(tsBuf.Len())) // #nosec G115 -- buffer Len() is always non-negative
+		buf.Write(bigB)
+
+		_, err = tsBuf.WriteTo(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+// generateHistogram records observations between minValue and maxValue into the exponential
+// buckets of the configured schema and returns them as an integer native histogram.
+func generateHistogram(
+	r *rand.Rand, minValue, maxValue int, timestamp int64, options HistogramTemplateOptions,
+) prompb.Histogram {
+	positive := make(map[int32]int64)
+	negative := make(map[int32]int64)
+
+	var zeroCount uint64
+
+	var sum float64
+
+	for range options.Observations {
+		v := valueBetween(r, minValue, maxValue)
+		sum += v
+
+		switch {
+		case math.Abs(v) <= options.ZeroThreshold:
+			zeroCount++
+		case v > 0:
+			positive[bucketIndex(v, options.Schema)]++
+		default:
+			negative[bucketIndex(-v, options.Schema)]++
+		}
+	}
+
+	positiveSpans, positiveDeltas := toSpansAndDeltas(positive)
+	negativeSpans, negativeDeltas := toSpansAndDeltas(negative)
+
+	return prompb.Histogram{
+		Count:          &prompb.Histogram_CountInt{CountInt: uint64(options.Observations)}, // #nosec G115 -- never negative
+		Sum:            sum,
+		Schema:         options.Schema,
+		ZeroThreshold:  options.ZeroThreshold,
+		ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: zeroCount},
+		PositiveSpans:  positiveSpans,
+		PositiveDeltas: positiveDeltas,
+		NegativeSpans:  negativeSpans,
+		NegativeDeltas: negativeDeltas,
+		Timestamp:      timestamp,
+	}
+}
+
+// bucketIndex returns the index of the exponential bucket v falls into. Bucket i of a schema
+// covers the range (base^(i-1), base^i] where base is 2^(2^-schema).
+func bucketIndex(v float64, schema int32) int32 {
+	return int32(math.Ceil(math.Log2(v) * math.Exp2(float64(schema))))
+}
+
+// toSpansAndDeltas converts a set of bucket counts into the spans and delta encoded counts
+// used by integer native histograms.
+func toSpansAndDeltas(buckets map[int32]int64) ([]prompb.BucketSpan, []int64) {
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+
+	indexes := make([]int32, 0, len(buckets))
+	for i := range buckets {
+		indexes = append(indexes, i)
+	}
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	spans := make([]prompb.BucketSpan, 0, 1)
+	deltas := make([]int64, 0, len(indexes))
+
+	var prevCount int64
+
+	for i, idx := range indexes {
+		switch {
+		case i == 0:
+			spans = append(spans, prompb.BucketSpan{Offset: idx, Length: 1})
+		case idx == indexes[i-1]+1:
+			spans[len(spans)-1].Length++
+		default:
+			spans = append(spans, prompb.BucketSpan{Offset: idx - indexes[i-1] - 1, Length: 1})
+		}
+
+		deltas = append(deltas, buckets[idx]-prevCount)
+		prevCount = buckets[idx]
+	}
+
+	return spans, deltas
+}
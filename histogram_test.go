@@ -0,0 +1,86 @@
+package remotewrite
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+func TestHistogramToPrompb(t *testing.T) {
+	t.Parallel()
+
+	h := Histogram{
+		Count:          5,
+		Sum:            12.5,
+		Schema:         1,
+		ZeroThreshold:  0.001,
+		ZeroCount:      1,
+		PositiveSpans:  []BucketSpan{{Offset: 0, Length: 2}, {Offset: 1, Length: 1}},
+		PositiveDeltas: []int64{1, 1, -1},
+		Timestamp:      1000,
+	}
+
+	got := h.toPrompb()
+	require.False(t, got.IsFloatHistogram())
+	require.Equal(t, uint64(5), got.GetCountInt())
+	require.Equal(t, uint64(1), got.GetZeroCountInt())
+	require.Equal(t, []prompb.BucketSpan{{Offset: 0, Length: 2}, {Offset: 1, Length: 1}}, got.PositiveSpans)
+	require.NoError(t, got.ToIntHistogram().Validate())
+
+	h.PositiveDeltas = nil
+	h.PositiveCounts = []float64{1, 2, 1}
+
+	got = h.toPrompb()
+	require.True(t, got.IsFloatHistogram())
+	require.InDelta(t, 5, got.GetCountFloat(), 0)
+	require.NoError(t, got.ToFloatHistogram().Validate())
+}
+
+func TestGenerateHistogramsFromPrecompiledTemplates(t *testing.T) {
+	t.Parallel()
+
+	template, err := compileLabelTemplates(map[string]string{
+		"__name__":  "k6_generated_histogram",
+		"series_id": "${series_id}",
+	})
+	require.NoError(t, err)
+
+	// #nosec G404 -- Using math/rand in test code, cryptographic randomness not required
+	r := rand.New(rand.NewSource(1))
+
+	buf, err := generateHistogramsFromPrecompiledTemplates(
+		r, -10, 100, 1000, 3, 6, template, HistogramTemplateOptions{Schema: 2, Observations: 50, ZeroThreshold: 1},
+	)
+	require.NoError(t, err)
+
+	req := new(prompb.WriteRequest)
+	require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+	require.Len(t, req.Timeseries, 3)
+
+	for i, ts := range req.Timeseries {
+		require.Equal(t, []prompb.Label{
+			{Name: "__name__", Value: "k6_generated_histogram"},
+			{Name: "series_id", Value: []string{"3", "4", "5"}[i]},
+		}, ts.Labels)
+		require.Empty(t, ts.Samples)
+		require.Len(t, ts.Histograms, 1)
+
+		h := ts.Histograms[0]
+		require.Equal(t, int64(1000), h.Timestamp)
+		require.Equal(t, int32(2), h.Schema)
+		require.Equal(t, uint64(50), h.GetCountInt())
+		require.NoError(t, h.ToIntHistogram().Validate())
+	}
+}
+
+func TestToSpansAndDeltas(t *testing.T) {
+	t.Parallel()
+
+	spans, deltas := toSpansAndDeltas(map[int32]int64{-1: 2, 0: 3, 3: 1, 4: 4})
+	require.Equal(t, []prompb.BucketSpan{{Offset: -1, Length: 2}, {Offset: 2, Length: 2}}, spans)
+	require.Equal(t, []int64{2, 1, -2, 3}, deltas)
+}
@@ -0,0 +1,92 @@
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Metadata represents the TYPE, HELP and UNIT metadata of a metric family.
+type Metadata struct {
+	// Type is one of counter, gauge, histogram, gaugehistogram, summary, info, stateset or unknown.
+	Type             string
+	MetricFamilyName string
+	Help             string
+	Unit             string
+}
+
+func (m Metadata) toPrompb() (prompb.MetricMetadata, error) {
+	typ := "UNKNOWN"
+	if m.Type != "" {
+		typ = strings.ToUpper(m.Type)
+	}
+
+	t, ok := prompb.MetricMetadata_MetricType_value[typ]
+	if !ok {
+		return prompb.MetricMetadata{}, fmt.Errorf("unsupported metric type %q", m.Type)
+	}
+
+	return prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_MetricType(t),
+		MetricFamilyName: m.MetricFamilyName,
+		Help:             m.Help,
+		Unit:             m.Unit,
+	}, nil
+}
+
+func toPrompbMetadata(metadata []Metadata) ([]prompb.MetricMetadata, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+
+	res := make([]prompb.MetricMetadata, 0, len(metadata))
+
+	for _, m := range metadata {
+		pm, err := m.toPrompb()
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, pm)
+	}
+
+	return res, nil
+}
+
+// StoreMetadata sends metric metadata, without any time series, to the Prometheus Remote Write endpoint.
+func (c *Client) StoreMetadata(metadata []Metadata) (Response, error) {
+	if c.protocol() == protocolV2 {
+		return newResponse(), errors.New("Remote Write 2.0 only sends metadata along with the series it describes")
+	}
+
+	return c.Store(nil, metadata)
+}
+
+// writeMetadata appends the metadata as WriteRequest fields to a request streamed by the template fast path.
+func writeMetadata(w *bytes.Buffer, metadata []Metadata) error {
+	var b []byte
+
+	for _, m := range metadata {
+		pm, err := m.toPrompb()
+		if err != nil {
+			return err
+		}
+
+		data, err := proto.Marshal(protoadapt.MessageV2Of(&pm))
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal metadata")
+		}
+
+		b = protowire.AppendTag(b[:0], 3, protowire.BytesType) //nolint:mnd // WriteRequest metadata field
+		b = protowire.AppendBytes(b, data)
+		w.Write(b)
+	}
+
+	return nil
+}
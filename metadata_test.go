@@ -0,0 +1,152 @@
+package remotewrite
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+func TestMetadataToPrompb(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		typ           string
+		expected      prompb.MetricMetadata_MetricType
+		expectedError string
+	}{
+		{typ: "", expected: prompb.MetricMetadata_UNKNOWN},
+		{typ: "counter", expected: prompb.MetricMetadata_COUNTER},
+		{typ: "Gauge", expected: prompb.MetricMetadata_GAUGE},
+		{typ: "histogram", expected: prompb.MetricMetadata_HISTOGRAM},
+		{typ: "summary", expected: prompb.MetricMetadata_SUMMARY},
+		{typ: "timer", expectedError: "unsupported metric type"},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.typ, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Metadata{Type: testcase.typ, MetricFamilyName: "metric", Help: "help", Unit: "seconds"}.toPrompb()
+			if testcase.expectedError != "" {
+				require.ErrorContains(t, err, testcase.expectedError)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, prompb.MetricMetadata{
+				Type:             testcase.expected,
+				MetricFamilyName: "metric",
+				Help:             "help",
+				Unit:             "seconds",
+			}, got)
+		})
+	}
+}
+
+func TestGenerateFromTemplatesMetadata(t *testing.T) {
+	t.Parallel()
+
+	compiled, err := compileLabelTemplates(map[string]string{"__name__": "k6_generated_metric"})
+	require.NoError(t, err)
+
+	// #nosec G404 -- Using math/rand in test code, cryptographic randomness not required
+	r := rand.New(rand.NewSource(1))
+
+	buf, _, err := generateFromPrecompiledTemplates(r, 1, 10, 1000, 0, 3, compiled, TemplateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, writeMetadata(buf, []Metadata{{Type: "gauge", MetricFamilyName: "k6_generated_metric", Help: "help"}}))
+
+	req := new(prompb.WriteRequest)
+	require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+	require.Len(t, req.Timeseries, 3)
+	require.Equal(t, []prompb.MetricMetadata{{
+		Type:             prompb.MetricMetadata_GAUGE,
+		MetricFamilyName: "k6_generated_metric",
+		Help:             "help",
+	}}, req.Metadata)
+}
+
+func TestToWriteV2RequestMetadata(t *testing.T) {
+	t.Parallel()
+
+	req := toWriteV2Request(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "__name__", Value: "requests_total"}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "other"}}},
+		},
+		Metadata: []prompb.MetricMetadata{{
+			Type:             prompb.MetricMetadata_COUNTER,
+			MetricFamilyName: "requests",
+			Help:             "Number of requests.",
+		}},
+	})
+
+	require.Equal(t, writev2.Metadata_METRIC_TYPE_COUNTER, req.Timeseries[0].Metadata.Type)
+	require.Equal(t, "Number of requests.", req.Symbols[req.Timeseries[0].Metadata.HelpRef])
+	require.Equal(t, writev2.Metadata{}, req.Timeseries[1].Metadata)
+}
+
+func TestStoreMetadataAfterFallback(t *testing.T) {
+	t.Parallel()
+
+	var metadata []prompb.MetricMetadata
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.0.2" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+
+			return
+		}
+
+		var req prompb.WriteRequest
+
+		data, err := snappy.Decode(nil, body)
+		if err == nil {
+			err = req.Unmarshal(data)
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		metadata = append(metadata, req.Metadata...)
+		w.WriteHeader(http.StatusOK)
+	})
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s", Protocol: protocolV2},
+		vu:  s.vu,
+	}
+
+	counter := []Metadata{{Type: "counter", MetricFamilyName: "metric", Help: "help"}}
+
+	_, err := c.StoreMetadata(counter)
+	require.ErrorContains(t, err, "only sends metadata along with the series")
+
+	_, err = c.Store([]Timeseries{{
+		Labels:  []Label{{Name: "__name__", Value: "metric"}},
+		Samples: []Sample{{Value: 1, Timestamp: 1000}},
+	}}, nil)
+	require.NoError(t, err)
+
+	// once the client fell back to Remote Write 1.0, the metadata is sent on its own
+	res, err := c.StoreMetadata(counter)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.Status)
+	require.Equal(t, []prompb.MetricMetadata{{
+		Type:             prompb.MetricMetadata_COUNTER,
+		MetricFamilyName: "metric",
+		Help:             "help",
+	}}, metadata)
+}
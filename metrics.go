@@ -0,0 +1,118 @@
+package remotewrite
+
+import (
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"go.k6.io/k6/v2/lib"
+	"go.k6.io/k6/v2/metrics"
+)
+
+// remoteWriteMetrics are the custom k6 metrics emitted for every remote-write request.
+type remoteWriteMetrics struct {
+	SamplesTotal      *metrics.Metric
+	HistogramsTotal   *metrics.Metric
+	SeriesTotal       *metrics.Metric
+	OutOfOrderTotal   *metrics.Metric
+	DuplicatesTotal   *metrics.Metric
+	UncompressedBytes *metrics.Metric
+	CompressedBytes   *metrics.Metric
+	EncodeDuration    *metrics.Metric
+}
+
+func registerMetrics(registry *metrics.Registry) (*remoteWriteMetrics, error) {
+	var (
+		m   remoteWriteMetrics
+		err error
+	)
+
+	for _, def := range []struct {
+		metric    **metrics.Metric
+		name      string
+		typ       metrics.MetricType
+		valueType metrics.ValueType
+	}{
+		{&m.SamplesTotal, "remote_write_samples_total", metrics.Counter, metrics.Default},
+		{&m.HistogramsTotal, "remote_write_histograms_total", metrics.Counter, metrics.Default},
+		{&m.SeriesTotal, "remote_write_series_total", metrics.Counter, metrics.Default},
+		{&m.OutOfOrderTotal, "remote_write_out_of_order_samples_total", metrics.Counter, metrics.Default},
+		{&m.DuplicatesTotal, "remote_write_duplicate_samples_total", metrics.Counter, metrics.Default},
+		{&m.UncompressedBytes, "remote_write_uncompressed_bytes", metrics.Counter, metrics.Data},
+		{&m.CompressedBytes, "remote_write_compressed_bytes", metrics.Counter, metrics.Data},
+		{&m.EncodeDuration, "remote_write_encode_duration", metrics.Trend, metrics.Time},
+	} {
+		*def.metric, err = registry.NewMetric(def.name, def.typ, def.valueType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &m, nil
+}
+
+// requestStats describes the content of a remote-write request for the extension's metrics.
+type requestStats struct {
+	series     int
+	samples    int
+	histograms int
+	// outOfOrder and duplicates count the generated samples moved back in time or duplicated.
+	outOfOrder int
+	duplicates int
+	// start is when the encoding of the request started, either marshalling or generating it.
+	start time.Time
+}
+
+func statsOf(req *prompb.WriteRequest, start time.Time) requestStats {
+	stats := requestStats{series: len(req.Timeseries), start: start}
+
+	for _, ts := range req.Timeseries {
+		stats.samples += len(ts.Samples)
+		stats.histograms += len(ts.Histograms)
+	}
+
+	return stats
+}
+
+// pushMetrics emits the extension's metrics for a sent request, tagged with the VU's current tags.
+// The samples, histograms and series of the request are only counted when it was written.
+func (c *Client) pushMetrics(
+	state *lib.State, stats requestStats, written bool, uncompressed, compressed int, encodeDuration time.Duration,
+) {
+	if c.metrics == nil {
+		return
+	}
+
+	now := time.Now()
+	tagsAndMeta := state.Tags.GetCurrentValues()
+
+	sample := func(m *metrics.Metric, value float64) metrics.Sample {
+		return metrics.Sample{
+			TimeSeries: metrics.TimeSeries{Metric: m, Tags: tagsAndMeta.Tags},
+			Time:       now,
+			Metadata:   tagsAndMeta.Metadata,
+			Value:      value,
+		}
+	}
+
+	samples := []metrics.Sample{
+		sample(c.metrics.UncompressedBytes, float64(uncompressed)),
+		sample(c.metrics.CompressedBytes, float64(compressed)),
+		sample(c.metrics.EncodeDuration, metrics.D(encodeDuration)),
+	}
+
+	if written {
+		samples = append(samples,
+			sample(c.metrics.SamplesTotal, float64(stats.samples)),
+			sample(c.metrics.HistogramsTotal, float64(stats.histograms)),
+			sample(c.metrics.SeriesTotal, float64(stats.series)),
+			sample(c.metrics.OutOfOrderTotal, float64(stats.outOfOrder)),
+			sample(c.metrics.DuplicatesTotal, float64(stats.duplicates)),
+		)
+	}
+
+	metrics.PushIfNotDone(c.vu.Context(), state.Samples, metrics.ConnectedSamples{
+		Samples: samples,
+		Tags:    tagsAndMeta.Tags,
+		Time:    now,
+	})
+}
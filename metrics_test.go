@@ -0,0 +1,144 @@
+package remotewrite
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/v2/metrics"
+)
+
+// collectMetrics closes the samples channel and returns the sum and the number of the samples of every metric.
+func collectMetrics(samples chan metrics.SampleContainer) (map[string]float64, map[string]int) {
+	close(samples)
+
+	values := make(map[string]float64)
+	counts := make(map[string]int)
+
+	for container := range samples {
+		for _, sample := range container.GetSamples() {
+			values[sample.Metric.Name] += sample.Value
+			counts[sample.Metric.Name]++
+		}
+	}
+
+	return values, counts
+}
+
+func TestStoreMetrics(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t)
+	samples := make(chan metrics.SampleContainer, 100)
+	s.vu.StateField.Samples = samples
+
+	m, err := registerMetrics(metrics.NewRegistry())
+	require.NoError(t, err)
+
+	c := &Client{
+		cfg:     &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:      s.vu,
+		metrics: m,
+	}
+
+	_, err = c.Store([]Timeseries{
+		{
+			Labels:  []Label{{Name: "__name__", Value: "a"}},
+			Samples: []Sample{{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "b"}},
+			Samples: []Sample{{Value: 3, Timestamp: 1000}},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "c_${series_id}"})
+	require.NoError(t, err)
+
+	_, err = c.StoreFromPrecompiledTemplates(1, 2, 1000, 0, 10, template, TemplateOptions{})
+	require.NoError(t, err)
+
+	_, err = c.StoreFromPrecompiledTemplates(1, 2, 1000, 0, 2, template, TemplateOptions{
+		OutOfOrder: OutOfOrderOptions{Fraction: 1, Delay: "1s", Duplicates: 1},
+	})
+	require.NoError(t, err)
+
+	values, _ := collectMetrics(samples)
+
+	require.InDelta(t, 17, values["remote_write_samples_total"], 0)
+	require.InDelta(t, 16, values["remote_write_series_total"], 0)
+	require.InDelta(t, 2, values["remote_write_out_of_order_samples_total"], 0)
+	require.InDelta(t, 2, values["remote_write_duplicate_samples_total"], 0)
+	require.Greater(t, values["remote_write_uncompressed_bytes"], float64(0))
+	require.Greater(t, values["remote_write_compressed_bytes"], float64(0))
+	require.Contains(t, values, "remote_write_encode_duration")
+}
+
+func TestStoreMetricsRejected(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		name     string
+		protocol string
+		status   int
+		requests int
+		samples  float64
+		series   float64
+	}{
+		// the 2.0 request rejected with 415 is sent again as a 1.0 request, the write is counted once
+		{name: "v2 fallback", protocol: protocolV2, status: http.StatusOK, requests: 2, samples: 3, series: 2},
+		{name: "rejected", protocol: protocolV1, status: http.StatusBadRequest, requests: 1, samples: 0},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+
+				if r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.0.2" {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+
+					return
+				}
+
+				w.WriteHeader(testcase.status)
+			})
+			samples := make(chan metrics.SampleContainer, 100)
+			s.vu.StateField.Samples = samples
+
+			m, err := registerMetrics(metrics.NewRegistry())
+			require.NoError(t, err)
+
+			c := &Client{
+				cfg:     &Config{Url: s.server.URL, Timeout: "10s", Protocol: testcase.protocol},
+				vu:      s.vu,
+				metrics: m,
+			}
+
+			res, err := c.Store([]Timeseries{
+				{
+					Labels:  []Label{{Name: "__name__", Value: "a"}},
+					Samples: []Sample{{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}},
+				},
+				{
+					Labels:  []Label{{Name: "__name__", Value: "b"}},
+					Samples: []Sample{{Value: 3, Timestamp: 1000}},
+				},
+			}, nil)
+			require.NoError(t, err)
+			require.Equal(t, testcase.status, res.Status)
+
+			values, counts := collectMetrics(samples)
+
+			require.Equal(t, testcase.requests, counts[metrics.HTTPReqsName])
+			require.InDelta(t, testcase.samples, values["remote_write_samples_total"], 0)
+			require.InDelta(t, testcase.series, values["remote_write_series_total"], 0)
+			require.Equal(t, 1, counts["remote_write_uncompressed_bytes"])
+			require.Equal(t, 1, counts["remote_write_compressed_bytes"])
+			require.Equal(t, 1, counts["remote_write_encode_duration"])
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package remotewrite
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// tokenExpiryDelta is how long before their expiry the OAuth 2.0 tokens are refreshed.
+	tokenExpiryDelta = 10 * time.Second
+	// tokenRequestTimeout bounds the requests to the token endpoint.
+	tokenRequestTimeout = 30 * time.Second
+	// maxTokenResponseSize bounds the responses of the token endpoint that are read.
+	maxTokenResponseSize = 1 << 20
+)
+
+// OAuth2Config configures the OAuth 2.0 client credentials flow authenticating the requests,
+// as in the remote_write configuration of Prometheus.
+type OAuth2Config struct {
+	ClientID         string            `json:"client_id"`          //nolint:tagliatelle // sobek use snake case for JSON keys
+	ClientSecret     string            `json:"client_secret"`      //nolint:tagliatelle // sobek use snake case for JSON keys
+	ClientSecretFile string            `json:"client_secret_file"` //nolint:tagliatelle // sobek use snake case for JSON keys
+	TokenURL         string            `json:"token_url"`          //nolint:tagliatelle // sobek use snake case for JSON keys
+	Scopes           []string          `json:"scopes"`
+	EndpointParams   map[string]string `json:"endpoint_params"` //nolint:tagliatelle // sobek use snake case for JSON keys
+}
+
+// tokenSources are the token sources of the OAuth 2.0 configurations, shared by the clients of all
+// the VUs so that they use the same tokens.
+var tokenSources = struct { //nolint:gochecknoglobals // the tokens are shared across VUs
+	sync.Mutex
+	sources map[string]*tokenSource
+}{sources: make(map[string]*tokenSource)}
+
+// tokenSource fetches the tokens of the client credentials flow, and caches them until they expire.
+type tokenSource struct {
+	cfg          OAuth2Config
+	clientSecret *secret
+	now          func() time.Time
+
+	mu          sync.Mutex
+	accessToken string
+	tokenType   string
+	expiry      time.Time
+}
+
+// sharedTokenSource returns the token source of the configuration, shared with the clients with the same one.
+func sharedTokenSource(cfg *OAuth2Config) (*tokenSource, error) {
+	if cfg.TokenURL == "" || cfg.ClientID == "" {
+		return nil, errors.New("oauth2 expects a token_url and a client_id")
+	}
+
+	if cfg.ClientSecret != "" && cfg.ClientSecretFile != "" {
+		return nil, errors.New("at most one of oauth2 client_secret and client_secret_file can be set")
+	}
+
+	key, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenSources.Lock()
+	defer tokenSources.Unlock()
+
+	source, ok := tokenSources.sources[string(key)]
+	if !ok {
+		source = &tokenSource{
+			cfg:          *cfg,
+			clientSecret: newSecret(cfg.ClientSecret, cfg.ClientSecretFile),
+			now:          time.Now,
+		}
+		tokenSources.sources[string(key)] = source
+	}
+
+	return source, nil
+}
+
+// authorization returns the value of the Authorization header, fetching a new token when
+// there's none or when it's about to expire, with the given transport.
+func (s *tokenSource) authorization(ctx context.Context, transport http.RoundTripper) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken == "" || (!s.expiry.IsZero() && !s.now().Before(s.expiry.Add(-tokenExpiryDelta))) {
+		err := s.fetch(ctx, transport)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	tokenType := s.tokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+
+	return tokenType + " " + s.accessToken, nil
+}
+
+func (s *tokenSource) fetch(ctx context.Context, transport http.RoundTripper) error {
+	clientSecret, err := s.clientSecret.get()
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+
+	for k, v := range s.cfg.EndpointParams {
+		form.Set(k, v)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(clientSecret))
+
+	client := &http.Client{Transport: transport, Timeout: tokenRequestTimeout}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "oauth2 token request failed")
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxTokenResponseSize))
+	if err != nil {
+		return errors.Wrap(err, "oauth2 token request failed")
+	}
+
+	if !ResponseCallback(res.StatusCode) {
+		return errors.Errorf("oauth2 token request failed with status %d: %s", res.StatusCode, body)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"` //nolint:tagliatelle // OAuth 2.0 response fields
+		TokenType   string `json:"token_type"`   //nolint:tagliatelle // OAuth 2.0 response fields
+		ExpiresIn   int64  `json:"expires_in"`   //nolint:tagliatelle // OAuth 2.0 response fields
+	}
+
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return errors.Wrap(err, "invalid oauth2 token response")
+	}
+
+	if token.AccessToken == "" {
+		return errors.New("oauth2 token response has no access_token")
+	}
+
+	s.accessToken, s.tokenType = token.AccessToken, token.TokenType
+	s.expiry = time.Time{}
+
+	// tokens without expiry are kept until the end of the test
+	if token.ExpiresIn > 0 {
+		s.expiry = s.now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	return nil
+}
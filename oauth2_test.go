@@ -0,0 +1,141 @@
+package remotewrite
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenServer returns an OAuth 2.0 token endpoint issuing the tokens token-1, token-2, ...
+// valid for an hour, and the number of tokens it issued. The endpoint is served over TLS with a
+// certificate that only the transport of the server's client trusts.
+func newTokenServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+
+	var issued atomic.Int64
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "client" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.PostFormValue("grant_type") != "client_credentials" ||
+			r.PostFormValue("scope") != "write read" ||
+			r.PostFormValue("audience") != "mimir" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, issued.Add(1))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &issued
+}
+
+func TestOAuth2(t *testing.T) {
+	t.Parallel()
+
+	tokens, issued := newTokenServer(t)
+	s, headers := newAuthServer(t)
+
+	// the tokens are fetched through the transport of the VU, the only one trusting the token endpoint
+	s.vu.StateField.Transport = tokens.Client().Transport
+
+	newClient := func() *Client {
+		return &Client{
+			cfg: &Config{
+				Url:     s.server.URL,
+				Timeout: "10s",
+				OAuth2: &OAuth2Config{
+					ClientID:       "client",
+					ClientSecret:   "secret",
+					TokenURL:       tokens.URL,
+					Scopes:         []string{"write", "read"},
+					EndpointParams: map[string]string{"audience": "mimir"},
+				},
+			},
+			vu: s.vu,
+		}
+	}
+
+	// the clients of different VUs share the token
+	for _, c := range []*Client{newClient(), newClient(), newClient()} {
+		_, err := c.Store([]Timeseries{{
+			Labels:  []Label{{Name: "__name__", Value: "metric"}},
+			Samples: []Sample{{Value: 1, Timestamp: 1000}},
+		}}, nil)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-1"}, headers())
+	require.Equal(t, int64(1), issued.Load())
+}
+
+func TestOAuth2Refresh(t *testing.T) {
+	t.Parallel()
+
+	tokens, issued := newTokenServer(t)
+
+	source, err := sharedTokenSource(&OAuth2Config{
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		TokenURL:       tokens.URL,
+		Scopes:         []string{"write", "read"},
+		EndpointParams: map[string]string{"audience": "mimir"},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	source.now = func() time.Time { return now }
+
+	authorization, err := source.authorization(t.Context(), tokens.Client().Transport)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token-1", authorization)
+
+	now = now.Add(time.Hour - 2*tokenExpiryDelta)
+
+	authorization, err = source.authorization(t.Context(), tokens.Client().Transport)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token-1", authorization)
+
+	// the token is refreshed before it expires
+	now = now.Add(tokenExpiryDelta)
+
+	authorization, err = source.authorization(t.Context(), tokens.Client().Transport)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token-2", authorization)
+	require.Equal(t, int64(2), issued.Load())
+}
+
+func TestOAuth2Errors(t *testing.T) {
+	t.Parallel()
+
+	tokens, _ := newTokenServer(t)
+
+	source, err := sharedTokenSource(&OAuth2Config{ClientID: "client", ClientSecret: "wrong", TokenURL: tokens.URL})
+	require.NoError(t, err)
+
+	_, err = source.authorization(t.Context(), tokens.Client().Transport)
+	require.ErrorContains(t, err, "status 401")
+
+	for _, cfg := range []Config{
+		{OAuth2: &OAuth2Config{ClientID: "client"}},
+		{OAuth2: &OAuth2Config{TokenURL: tokens.URL}},
+		{OAuth2: &OAuth2Config{ClientID: "client", TokenURL: tokens.URL, ClientSecret: "a", ClientSecretFile: "b"}},
+		{OAuth2: &OAuth2Config{ClientID: "client", TokenURL: tokens.URL}, BearerToken: "token"},
+	} {
+		_, err := newAuthorizer(&cfg)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,79 @@
+package remotewrite
+
+import (
+	"math/rand"
+
+	"github.com/pkg/errors"
+)
+
+// OutOfOrderOptions moves a fraction of the samples generated from templates back in time, and
+// duplicates some of them with a different value, to exercise the out-of-order ingestion window
+// and the duplicate sample errors of the receivers.
+type OutOfOrderOptions struct {
+	// Fraction is the share of the samples whose timestamp is moved backwards, from 0 (the default) to 1.
+	Fraction float64
+	// Delay is how far back the timestamps are moved, e.g. "10m". When MaxDelay is set, the delay
+	// of every sample is drawn between Delay and MaxDelay.
+	Delay    string
+	MaxDelay string
+	// Duplicates is the share of the series that get a second sample with the same timestamp and
+	// a different value, from 0 (the default) to 1.
+	Duplicates float64
+}
+
+// outOfOrder draws the delayed and duplicated samples.
+type outOfOrder struct {
+	fraction   float64
+	minDelay   int64
+	maxDelay   int64
+	duplicates float64
+}
+
+func (options OutOfOrderOptions) compile() (outOfOrder, error) {
+	if options.Fraction < 0 || options.Fraction > 1 || options.Duplicates < 0 || options.Duplicates > 1 {
+		return outOfOrder{}, errors.New("the out of order fraction and duplicates must be between 0 and 1")
+	}
+
+	o := outOfOrder{fraction: options.Fraction, duplicates: options.Duplicates}
+
+	if o.fraction == 0 {
+		return o, nil
+	}
+
+	minDelay, err := parseValueDuration(options.Delay, 0)
+	if err != nil {
+		return outOfOrder{}, errors.Wrap(err, "invalid out of order delay")
+	}
+
+	maxDelay, err := parseValueDuration(options.MaxDelay, minDelay)
+	if err != nil {
+		return outOfOrder{}, errors.Wrap(err, "invalid out of order max_delay")
+	}
+
+	o.minDelay, o.maxDelay = minDelay.Milliseconds(), maxDelay.Milliseconds()
+
+	if o.maxDelay <= 0 || o.maxDelay < o.minDelay {
+		return outOfOrder{}, errors.New("out of order samples expect a delay, and a max_delay after it")
+	}
+
+	return o, nil
+}
+
+// timestamp returns the timestamp of a sample, moved backwards for a fraction of them.
+func (o outOfOrder) timestamp(r *rand.Rand, timestamp int64) (int64, bool) {
+	if o.fraction == 0 || r.Float64() >= o.fraction {
+		return timestamp, false
+	}
+
+	delay := o.minDelay
+	if o.maxDelay > o.minDelay {
+		delay += r.Int63n(o.maxDelay - o.minDelay + 1)
+	}
+
+	return max(timestamp-delay, 0), true
+}
+
+// duplicate reports whether the sample gets a duplicate.
+func (o outOfOrder) duplicate(r *rand.Rand) bool {
+	return o.duplicates > 0 && r.Float64() < o.duplicates
+}
@@ -0,0 +1,110 @@
+package remotewrite
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+func TestGenerateFromTemplatesOutOfOrder(t *testing.T) {
+	t.Parallel()
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "metric_${series_id}"})
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic test data
+
+	const timestamp = 10_000_000
+
+	buf, stats, err := generateFromPrecompiledTemplates(r, 0, 100, timestamp, 0, 1000, template, TemplateOptions{
+		OutOfOrder: OutOfOrderOptions{Fraction: 0.2, Delay: "1m", MaxDelay: "2m", Duplicates: 0.1},
+	})
+	require.NoError(t, err)
+
+	req := new(prompb.WriteRequest)
+	require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+	require.Len(t, req.Timeseries, stats.series)
+	require.Equal(t, 1000+stats.duplicates, stats.samples)
+	require.InDelta(t, 200, stats.outOfOrder, 50)
+	require.InDelta(t, 100, stats.duplicates, 40)
+
+	delayed, duplicates := 0, 0
+
+	for i, ts := range req.Timeseries {
+		sample := ts.Samples[0]
+
+		if sample.Timestamp != timestamp {
+			require.GreaterOrEqual(t, sample.Timestamp, int64(timestamp-120_000))
+			require.LessOrEqual(t, sample.Timestamp, int64(timestamp-60_000))
+
+			delayed++
+		}
+
+		// a duplicate follows its sample, with the same labels and timestamp and another value
+		if i > 0 && ts.Labels[0].Value == req.Timeseries[i-1].Labels[0].Value {
+			previous := req.Timeseries[i-1].Samples[0]
+			require.Equal(t, previous.Timestamp, sample.Timestamp)
+			require.NotEqual(t, previous.Value, sample.Value)
+
+			duplicates++
+
+			if sample.Timestamp != timestamp {
+				delayed--
+			}
+		}
+	}
+
+	require.Equal(t, stats.outOfOrder, delayed)
+	require.Equal(t, stats.duplicates, duplicates)
+}
+
+func TestStoreFromTemplatesOutOfOrder(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t)
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "metric_${series_id}"})
+	require.NoError(t, err)
+
+	res, err := c.StoreFromPrecompiledTemplates(0, 100, 1_000_000, 0, 10, template, TemplateOptions{
+		OutOfOrder: OutOfOrderOptions{Fraction: 1, Delay: "30s", Duplicates: 1},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10, res.OutOfOrderSamples)
+	require.Equal(t, 10, res.DuplicateSamples)
+}
+
+func TestOutOfOrderOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, options := range []OutOfOrderOptions{
+		{Fraction: -1, Delay: "1m"},
+		{Duplicates: 2},
+		{Fraction: 0.5},
+		{Fraction: 0.5, Delay: "soon"},
+		{Fraction: 0.5, Delay: "1m", MaxDelay: "30s"},
+	} {
+		_, err := options.compile()
+		require.Error(t, err, options)
+	}
+
+	o, err := OutOfOrderOptions{Fraction: 1, MaxDelay: "1s"}.compile()
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic test data
+
+	for range 100 {
+		timestamp, delayed := o.timestamp(r, 5000)
+		require.True(t, delayed)
+		require.GreaterOrEqual(t, timestamp, int64(4000))
+		require.LessOrEqual(t, timestamp, int64(5000))
+	}
+}
@@ -0,0 +1,460 @@
+package remotewrite
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/sobek"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/xhit/go-str2duration/v2"
+	"go.k6.io/k6/v2/js/common"
+)
+
+const (
+	defaultQueueCapacity          = 10000
+	defaultQueueMinShards         = 1
+	defaultQueueMaxShards         = 50
+	defaultQueueMaxSamplesPerSend = 2000
+	defaultQueueBatchSendDeadline = "5s"
+
+	// The resharding constants are the ones of the Prometheus queue manager.
+	shardUpdateDuration    = 10 * time.Second
+	shardToleranceFraction = 0.3
+	backlogCatchupFraction = 0.05
+)
+
+var (
+	// ErrInvalidQueueArguments is returned when the Queue constructor isn't given a Client and a QueueConfig.
+	ErrInvalidQueueArguments = errors.New("Queue constructor expects a Client and an optional QueueConfig")
+	// ErrQueueStopped is returned when appending to a stopped queue.
+	ErrQueueStopped = errors.New("queue is stopped")
+)
+
+// QueueConfig configures a Queue, mirroring the queue_config of a Prometheus remote_write.
+type QueueConfig struct {
+	// Capacity is the number of series buffered per shard before append blocks.
+	Capacity          int    `json:"capacity"`
+	MinShards         int    `json:"min_shards"`           //nolint:tagliatelle // sobek use snake case for JSON keys
+	MaxShards         int    `json:"max_shards"`           //nolint:tagliatelle // sobek use snake case for JSON keys
+	MaxSamplesPerSend int    `json:"max_samples_per_send"` //nolint:tagliatelle // sobek use snake case for JSON keys
+	BatchSendDeadline string `json:"batch_send_deadline"`  //nolint:tagliatelle // sobek use snake case for JSON keys
+}
+
+// QueueStats are the counters of a Queue.
+type QueueStats struct {
+	SamplesIn     int64
+	SamplesSent   int64
+	SamplesFailed int64
+	Pending       int64
+	Shards        int
+}
+
+// Queue emulates a Prometheus remote-write queue on top of a Client. Appended series are sharded
+// by their labels, batched by max_samples_per_send and batch_send_deadline, and sent by every shard
+// in the background. The number of shards follows the incoming sample rate like Prometheus does.
+type Queue struct {
+	client   *Client
+	cfg      QueueConfig
+	deadline time.Duration
+
+	// mu guards the shards, which are replaced while resharding, and the context they run in along
+	// with the reshard loop, which are replaced when the context of the VU changes.
+	mu      sync.RWMutex
+	ctx     context.Context //nolint:containedctx // the context of the VU the shards run in
+	shards  []*shard
+	quit    chan struct{}
+	stopped bool
+
+	samplesIn     atomic.Int64
+	samplesSent   atomic.Int64
+	samplesFailed atomic.Int64
+	sendNanos     atomic.Int64
+}
+
+type shard struct {
+	queue chan prompb.TimeSeries
+	done  chan struct{}
+}
+
+// xqueue constructs a new Queue instance.
+func (r *RemoteWrite) xqueue(c sobek.ConstructorCall) *sobek.Object {
+	rt := r.vu.Runtime()
+
+	client, ok := c.Argument(0).Export().(*Client)
+	if !ok {
+		common.Throw(rt, ErrInvalidQueueArguments)
+	}
+
+	var cfg QueueConfig
+
+	if !sobek.IsUndefined(c.Argument(1)) {
+		err := rt.ExportTo(c.Argument(1), &cfg)
+		if err != nil {
+			common.Throw(rt, ErrInvalidQueueArguments)
+		}
+	}
+
+	q, err := newQueue(client, cfg)
+	if err != nil {
+		common.Throw(rt, err)
+	}
+
+	return rt.ToValue(q).ToObject(rt)
+}
+
+func newQueue(client *Client, cfg QueueConfig) (*Queue, error) {
+	if cfg.Capacity == 0 {
+		cfg.Capacity = defaultQueueCapacity
+	}
+
+	if cfg.MinShards == 0 {
+		cfg.MinShards = defaultQueueMinShards
+	}
+
+	if cfg.MaxShards == 0 {
+		cfg.MaxShards = max(defaultQueueMaxShards, cfg.MinShards)
+	}
+
+	if cfg.MaxSamplesPerSend == 0 {
+		cfg.MaxSamplesPerSend = defaultQueueMaxSamplesPerSend
+	}
+
+	if cfg.BatchSendDeadline == "" {
+		cfg.BatchSendDeadline = defaultQueueBatchSendDeadline
+	}
+
+	if cfg.Capacity < 0 || cfg.MinShards < 0 || cfg.MaxSamplesPerSend < 0 || cfg.MinShards > cfg.MaxShards {
+		return nil, errors.New("invalid queue config, sizes must be positive and min_shards <= max_shards")
+	}
+
+	deadline, err := str2duration.ParseDuration(cfg.BatchSendDeadline)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid batch_send_deadline")
+	}
+
+	return &Queue{
+		client:   client,
+		cfg:      cfg,
+		deadline: deadline,
+	}, nil
+}
+
+// Append queues series to be sent by the shards. It blocks while the shard of a series is full.
+// The shards are started by the first call, so that they run in the context of the VU's iterations,
+// and started again when the VU runs in a new context, such as the one of the next scenario.
+func (q *Queue) Append(ts []Timeseries) error {
+	ctx := q.client.vu.Context()
+
+	q.mu.Lock()
+
+	if !q.stopped && q.ctx != ctx {
+		q.start(ctx)
+	}
+
+	q.mu.Unlock()
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.stopped {
+		return ErrQueueStopped
+	}
+
+	for _, t := range ts {
+		// the shards stop reading once the context is done, series queued afterwards would never be counted
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		series := FromTimeseriesToPrometheusTimeseries(t)
+		s := q.shards[hashLabels(series.Labels)%uint64(len(q.shards))]
+
+		select {
+		case s.queue <- series:
+			q.samplesIn.Add(int64(len(series.Samples) + len(series.Histograms)))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Flush sends all the queued series and waits for the requests to complete.
+func (q *Queue) Flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped || q.shards == nil {
+		return
+	}
+
+	// the shards of a previous context are flushed when they are replaced
+	if ctx := q.client.vu.Context(); ctx != q.ctx {
+		q.start(ctx)
+
+		return
+	}
+
+	q.reshard(q.ctx, len(q.shards))
+}
+
+// Stop flushes the queue and stops its shards, series can't be appended afterwards.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+
+	if q.stopped {
+		q.mu.Unlock()
+
+		return
+	}
+
+	q.stopped = true
+	shards := q.shards
+
+	// a queue stopped before any append never starts its shards
+	if q.quit != nil {
+		close(q.quit)
+	}
+
+	q.mu.Unlock()
+
+	stopShards(shards)
+}
+
+// Stats returns the counters of the queue.
+func (q *Queue) Stats() QueueStats {
+	q.mu.RLock()
+	shards := len(q.shards)
+	q.mu.RUnlock()
+
+	in, sent, failed := q.samplesIn.Load(), q.samplesSent.Load(), q.samplesFailed.Load()
+
+	return QueueStats{
+		SamplesIn:     in,
+		SamplesSent:   sent,
+		SamplesFailed: failed,
+		Pending:       in - sent - failed,
+		Shards:        shards,
+	}
+}
+
+// start replaces the shards and the reshard loop with ones running in ctx. The shards of the previous
+// context send their series first, or count them as failed when that context is done.
+// It must be called with mu held.
+func (q *Queue) start(ctx context.Context) {
+	n := q.cfg.MinShards
+
+	if q.quit != nil {
+		close(q.quit)
+		stopShards(q.shards)
+
+		n = len(q.shards)
+	}
+
+	q.ctx = ctx
+	q.quit = make(chan struct{})
+	q.shards = q.startShards(ctx, n)
+
+	go q.reshardLoop(ctx, q.quit)
+}
+
+func (q *Queue) startShards(ctx context.Context, n int) []*shard {
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			queue: make(chan prompb.TimeSeries, q.cfg.Capacity),
+			done:  make(chan struct{}),
+		}
+
+		go q.runShard(ctx, shards[i])
+	}
+
+	return shards
+}
+
+// stopShards closes the shards' queues and waits for them to send the series left in them.
+func stopShards(shards []*shard) {
+	for _, s := range shards {
+		close(s.queue)
+	}
+
+	for _, s := range shards {
+		<-s.done
+	}
+}
+
+// runShard batches the series of a shard until either max_samples_per_send samples are
+// pending or batch_send_deadline elapsed, and sends them.
+func (q *Queue) runShard(ctx context.Context, s *shard) {
+	defer close(s.done)
+
+	batch := make([]prompb.TimeSeries, 0, q.cfg.MaxSamplesPerSend)
+	samples := 0
+
+	timer := time.NewTimer(q.deadline)
+	defer timer.Stop()
+
+	send := func() {
+		switch {
+		case len(batch) == 0:
+		case ctx.Err() != nil:
+			// the queue was closed after the end of the context, the series can't be sent anymore
+			q.samplesFailed.Add(int64(samples))
+		default:
+			q.sendBatch(batch, samples)
+		}
+
+		batch = batch[:0]
+		samples = 0
+
+		timer.Reset(q.deadline)
+	}
+
+	for {
+		select {
+		case series, ok := <-s.queue:
+			if !ok {
+				send()
+
+				return
+			}
+
+			batch = append(batch, series)
+			samples += len(series.Samples) + len(series.Histograms)
+
+			if samples >= q.cfg.MaxSamplesPerSend {
+				send()
+			}
+		case <-timer.C:
+			send()
+		case <-ctx.Done():
+			q.samplesFailed.Add(int64(samples + drain(s.queue)))
+
+			return
+		}
+	}
+}
+
+// drain empties a shard's queue without waiting for new series and returns the number of samples
+// it held, so that they can be counted as failed when the test ends before they are sent.
+func drain(queue <-chan prompb.TimeSeries) int {
+	samples := 0
+
+	for {
+		select {
+		case series, ok := <-queue:
+			if !ok {
+				return samples
+			}
+
+			samples += len(series.Samples) + len(series.Histograms)
+		default:
+			return samples
+		}
+	}
+}
+
+func (q *Queue) sendBatch(batch []prompb.TimeSeries, samples int) {
+	start := time.Now()
+
+	res, err := q.client.store(batch, nil, start)
+
+	q.sendNanos.Add(int64(time.Since(start)))
+
+	if err != nil || !ResponseCallback(res.Status) {
+		q.samplesFailed.Add(int64(samples))
+
+		return
+	}
+
+	q.samplesSent.Add(int64(samples))
+}
+
+// reshardLoop periodically adjusts the number of shards to the incoming sample rate, until quit is
+// closed because the queue is stopped or restarted in another context.
+func (q *Queue) reshardLoop(ctx context.Context, quit <-chan struct{}) {
+	ticker := time.NewTicker(shardUpdateDuration)
+	defer ticker.Stop()
+
+	var lastIn, lastSent, lastNanos int64
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		in, sent, nanos := q.samplesIn.Load(), q.samplesSent.Load()+q.samplesFailed.Load(), q.sendNanos.Load()
+		if sent == lastSent {
+			// nothing was sent since the last update, the time it takes to send a sample is unknown
+			continue
+		}
+
+		timePerSample := time.Duration(nanos-lastNanos).Seconds() / float64(sent-lastSent)
+		inRate := float64(in-lastIn) / shardUpdateDuration.Seconds()
+		lastIn, lastSent, lastNanos = in, sent, nanos
+
+		q.mu.Lock()
+
+		// the shards may have been replaced while the lock was released
+		select {
+		case <-quit:
+			q.mu.Unlock()
+
+			return
+		default:
+		}
+
+		desired := desiredShards(len(q.shards), q.cfg.MinShards, q.cfg.MaxShards, inRate, timePerSample, float64(in-sent))
+		if desired != len(q.shards) {
+			q.reshard(ctx, desired)
+		}
+
+		q.mu.Unlock()
+	}
+}
+
+// reshard replaces the shards with n new ones, after the current ones sent their series.
+// It must be called with mu held.
+func (q *Queue) reshard(ctx context.Context, n int) {
+	stopShards(q.shards)
+	q.shards = q.startShards(ctx, n)
+}
+
+// desiredShards computes the number of shards needed to keep up with the incoming samples
+// and catch up with the pending ones, given how long it takes to send a sample. Like in Prometheus,
+// the current number of shards is kept when the difference is within the tolerance.
+func desiredShards(current, minShards, maxShards int, inRate, timePerSample, pending float64) int {
+	desired := timePerSample * (inRate + backlogCatchupFraction*pending)
+
+	lower := float64(current) * (1 - shardToleranceFraction)
+	upper := float64(current) * (1 + shardToleranceFraction)
+
+	if lower <= desired && desired <= upper {
+		return current
+	}
+
+	return min(max(int(math.Ceil(desired)), minShards), maxShards)
+}
+
+func hashLabels(labels []prompb.Label) uint64 {
+	h := fnv.New64a()
+
+	for _, l := range labels {
+		_, _ = h.Write([]byte(l.Name))
+		_, _ = h.Write([]byte{0xff})
+		_, _ = h.Write([]byte(l.Value))
+		_, _ = h.Write([]byte{0xff})
+	}
+
+	return h.Sum64()
+}
@@ -0,0 +1,229 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu      sync.Mutex
+		batches []int
+	)
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		var req prompb.WriteRequest
+
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			var data []byte
+
+			data, err = snappy.Decode(nil, body)
+			if err == nil {
+				err = req.Unmarshal(data)
+			}
+		}
+
+		// the invalid requests fail, and so the samples sent that are checked below
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		samples := 0
+		for _, ts := range req.Timeseries {
+			samples += len(ts.Samples)
+		}
+
+		mu.Lock()
+		batches = append(batches, samples)
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	})
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	q, err := newQueue(c, QueueConfig{MinShards: 2, MaxSamplesPerSend: 10, BatchSendDeadline: "1h"})
+	require.NoError(t, err)
+
+	for i := range 5 {
+		ts := make([]Timeseries, 0, 20)
+		for j := range 20 {
+			ts = append(ts, Timeseries{
+				Labels:  []Label{{Name: "__name__", Value: "metric_" + strconv.Itoa(j)}},
+				Samples: []Sample{{Value: float64(i), Timestamp: int64(1000 * (i + 1))}},
+			})
+		}
+
+		require.NoError(t, q.Append(ts))
+	}
+
+	q.Flush()
+
+	stats := q.Stats()
+	require.Equal(t, QueueStats{SamplesIn: 100, SamplesSent: 100, Shards: 2}, stats)
+
+	q.Stop()
+	require.ErrorIs(t, q.Append(nil), ErrQueueStopped)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	total := 0
+	for _, samples := range batches {
+		require.LessOrEqual(t, samples, 10)
+
+		total += samples
+	}
+
+	require.Equal(t, 100, total)
+}
+
+func TestQueueCanceled(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t)
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	s.vu.CtxField = ctx
+
+	q, err := newQueue(c, QueueConfig{MinShards: 2, MaxSamplesPerSend: 1000, BatchSendDeadline: "1h"})
+	require.NoError(t, err)
+
+	ts := make([]Timeseries, 0, 50)
+	for i := range 50 {
+		ts = append(ts, Timeseries{
+			Labels:  []Label{{Name: "__name__", Value: "metric_" + strconv.Itoa(i)}},
+			Samples: []Sample{{Value: float64(i), Timestamp: 1000}},
+		})
+	}
+
+	require.NoError(t, q.Append(ts))
+
+	cancel()
+
+	// the series left in the shards are counted as failed
+	require.Eventually(t, func() bool {
+		return q.Stats().Pending == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, QueueStats{SamplesIn: 50, SamplesFailed: 50, Shards: 2}, q.Stats())
+	require.ErrorIs(t, q.Append(ts), context.Canceled)
+	require.Zero(t, atomic.LoadInt64(s.count))
+}
+
+func TestQueueRestart(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t)
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	first, cancel := context.WithCancel(t.Context())
+	s.vu.CtxField = first
+
+	q, err := newQueue(c, QueueConfig{MinShards: 2, MaxSamplesPerSend: 1000, BatchSendDeadline: "1h"})
+	require.NoError(t, err)
+
+	ts := make([]Timeseries, 0, 20)
+	for i := range 20 {
+		ts = append(ts, Timeseries{
+			Labels:  []Label{{Name: "__name__", Value: "metric_" + strconv.Itoa(i)}},
+			Samples: []Sample{{Value: float64(i), Timestamp: 1000}},
+		})
+	}
+
+	require.NoError(t, q.Append(ts))
+
+	cancel()
+
+	// the series of the next scenario are sent by new shards
+	s.vu.CtxField = t.Context()
+
+	require.NoError(t, q.Append(ts))
+
+	q.Flush()
+
+	require.Equal(t, QueueStats{SamplesIn: 40, SamplesSent: 20, SamplesFailed: 20, Shards: 2}, q.Stats())
+	require.Equal(t, int64(2), atomic.LoadInt64(s.count))
+
+	// a flush in a new context flushes the shards of the previous one
+	require.NoError(t, q.Append(ts))
+
+	s.vu.CtxField = context.WithValue(t.Context(), struct{}{}, "next")
+
+	q.Flush()
+
+	require.Equal(t, QueueStats{SamplesIn: 60, SamplesSent: 40, SamplesFailed: 20, Shards: 2}, q.Stats())
+
+	q.Stop()
+}
+
+func TestQueueConfig(t *testing.T) {
+	t.Parallel()
+
+	q, err := newQueue(&Client{}, QueueConfig{})
+	require.NoError(t, err)
+	require.Equal(t, QueueConfig{
+		Capacity:          defaultQueueCapacity,
+		MinShards:         defaultQueueMinShards,
+		MaxShards:         defaultQueueMaxShards,
+		MaxSamplesPerSend: defaultQueueMaxSamplesPerSend,
+		BatchSendDeadline: defaultQueueBatchSendDeadline,
+	}, q.cfg)
+
+	_, err = newQueue(&Client{}, QueueConfig{MinShards: 10, MaxShards: 2})
+	require.Error(t, err)
+
+	_, err = newQueue(&Client{}, QueueConfig{BatchSendDeadline: "soon"})
+	require.Error(t, err)
+}
+
+func TestDesiredShards(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		name          string
+		current       int
+		inRate        float64
+		timePerSample float64
+		pending       float64
+		expected      int
+	}{
+		{name: "within tolerance", current: 10, inRate: 1408, timePerSample: 1.0 / 128, expected: 10},
+		{name: "scale up", current: 1, inRate: 1216, timePerSample: 1.0 / 128, expected: 10},
+		{name: "scale down", current: 10, inRate: 64, timePerSample: 1.0 / 128, expected: 1},
+		{name: "backlog", current: 1, timePerSample: 1.0 / 128, pending: 11520, expected: 5},
+		{name: "max shards", current: 1, inRate: 1e6, timePerSample: 1.0 / 128, expected: 50},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, testcase.expected, desiredShards(
+				testcase.current, 1, 50, testcase.inRate, testcase.timePerSample, testcase.pending,
+			))
+		})
+	}
+}
@@ -6,18 +6,19 @@ package remotewrite
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
 	"net/http"
-	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang/snappy"
 	"github.com/grafana/sobek"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/prompb"
@@ -36,6 +37,8 @@ var (
 	ErrInvalidConfig = errors.New("Client constructor expects first argument to be Config")
 	// ErrURLRequired is returned when the URL is not provided in the configuration.
 	ErrURLRequired = errors.New("url is required")
+	// ErrUnsupportedProtocol is returned when the configured remote-write protocol is unknown.
+	ErrUnsupportedProtocol = errors.New("protocol must be either \"v1\" or \"v2\"")
 )
 
 // Register the extension on module initialization, available to
@@ -46,7 +49,8 @@ func init() {
 
 // RemoteWrite is the k6 extension for interacting Prometheus Remote Write endpoints.
 type RemoteWrite struct {
-	vu modules.VU
+	vu      modules.VU
+	metrics *remoteWriteMetrics
 }
 
 type remoteWriteModule struct{}
@@ -54,8 +58,14 @@ type remoteWriteModule struct{}
 var _ modules.Module = &remoteWriteModule{}
 
 func (r *remoteWriteModule) NewModuleInstance(vu modules.VU) modules.Instance {
+	m, err := registerMetrics(vu.InitEnv().Registry)
+	if err != nil {
+		common.Throw(vu.Runtime(), err)
+	}
+
 	return &RemoteWrite{
-		vu: vu,
+		vu:      vu,
+		metrics: m,
 	}
 }
 
@@ -64,7 +74,9 @@ func (r *RemoteWrite) Exports() modules.Exports {
 	return modules.Exports{
 		Named: map[string]any{
 			"Client":                   r.xclient,
+			"Queue":                    r.xqueue,
 			"Sample":                   r.sample,
+			"SeriesSet":                r.seriesSet,
 			"Timeseries":               r.timeseries,
 			"precompileLabelTemplates": compileLabelTemplates,
 		},
@@ -73,17 +85,44 @@ func (r *RemoteWrite) Exports() modules.Exports {
 
 // Client is the client wrapper.
 type Client struct {
-	cfg *Config
-	vu  modules.VU
+	cfg     *Config
+	vu      modules.VU
+	metrics *remoteWriteMetrics
+
+	encoderOnce sync.Once
+	encoder     *encoder
+	encoderErr  error
+
+	authOnce sync.Once
+	auth     *authorizer
+	authErr  error
+
+	// downgraded is set once the receiver rejected a Remote Write 2.0 request, the following
+	// requests are sent with the 1.0 protocol.
+	downgraded atomic.Bool
 }
 
 // Config holds the configuration for the Prometheus Remote Write client.
 type Config struct {
-	Url        string            `json:"url"`        //nolint:revive // sobek exports value here
-	UserAgent  string            `json:"user_agent"` //nolint:tagliatelle // sobek use snake case for JSON keys
-	Timeout    string            `json:"timeout"`
-	TenantName string            `json:"tenant_name"` //nolint:tagliatelle // sobek use snake case for JSON keys
-	Headers    map[string]string `json:"headers"`
+	Url         string            `json:"url"`        //nolint:revive // sobek exports value here
+	UserAgent   string            `json:"user_agent"` //nolint:tagliatelle // sobek use snake case for JSON keys
+	Timeout     string            `json:"timeout"`
+	TenantName  string            `json:"tenant_name"` //nolint:tagliatelle // sobek use snake case for JSON keys
+	Headers     map[string]string `json:"headers"`
+	Protocol    string            `json:"protocol"`
+	Compression string            `json:"compression"`
+	Retry       RetryConfig       `json:"retry"`
+	// Seed makes the generated values reproducible, 0 seeds them with the current time.
+	Seed int64 `json:"seed"`
+	// BasicAuth, BearerToken and BearerTokenFile authenticate the requests, the files are read again
+	// every minute. Credentials embedded in Url are sent as basic auth when none is configured.
+	BasicAuth       *BasicAuthConfig `json:"basic_auth"`        //nolint:tagliatelle // sobek use snake case for JSON keys
+	BearerToken     string           `json:"bearer_token"`      //nolint:tagliatelle // sobek use snake case for JSON keys
+	BearerTokenFile string           `json:"bearer_token_file"` //nolint:tagliatelle // sobek use snake case for JSON keys
+	// OAuth2 authenticates the requests with the tokens of the client credentials flow, shared by all the VUs.
+	OAuth2 *OAuth2Config `js:"oauth2" json:"oauth2"`
+	// SigV4 signs the requests for Amazon Managed Service for Prometheus.
+	SigV4 *SigV4Config `js:"sigv4" json:"sigv4"`
 }
 
 // xclient constructs a new Remote Write Client instance.
@@ -109,16 +148,44 @@ func (r *RemoteWrite) xclient(c sobek.ConstructorCall) *sobek.Object {
 		config.Timeout = "10s"
 	}
 
+	switch config.Protocol {
+	case "":
+		config.Protocol = protocolV1
+	case protocolV1, protocolV2:
+	default:
+		common.Throw(rt, ErrUnsupportedProtocol)
+	}
+
+	enc, err := newEncoder(config.Compression)
+	if err != nil {
+		common.Throw(rt, err)
+	}
+
+	_, _, err = config.Retry.backoffs()
+	if err != nil {
+		common.Throw(rt, err)
+	}
+
+	auth, err := newAuthorizer(&config)
+	if err != nil {
+		common.Throw(rt, err)
+	}
+
 	return rt.ToValue(&Client{
-		cfg: &config,
-		vu:  r.vu,
+		cfg:     &config,
+		vu:      r.vu,
+		metrics: r.metrics,
+		encoder: enc,
+		auth:    auth,
 	}).ToObject(rt)
 }
 
-// Timeseries represents a Prometheus time series with labels and samples.
+// Timeseries represents a Prometheus time series with labels, samples, native histograms and exemplars.
 type Timeseries struct {
-	Labels  []Label
-	Samples []Sample
+	Labels     []Label
+	Samples    []Sample
+	Histograms []Histogram
+	Exemplars  []Exemplar
 }
 
 // Label represents a Prometheus label name-value pair.
@@ -130,6 +197,36 @@ type Label struct {
 type Sample struct {
 	Value     float64
 	Timestamp int64
+	// Stale sends the sample as a staleness marker, whatever its value. The marker is a NaN with
+	// specific bits that JS can't represent, its NaNs are all the same.
+	Stale bool
+}
+
+// Exemplar represents a Prometheus exemplar, such as a sample linked to a trace through a trace_id label.
+type Exemplar struct {
+	Labels    []Label
+	Value     float64
+	Timestamp int64
+}
+
+// Response is the result of a remote-write request. On top of the k6 HTTP response it carries
+// the write statistics that Remote Write 2.0 receivers report through response headers.
+type Response struct {
+	httpext.Response `js:"-"`
+
+	SamplesWritten    int64
+	HistogramsWritten int64
+	ExemplarsWritten  int64
+	// Attempts is the number of times the request was sent, including retries.
+	Attempts int
+	// OutOfOrderSamples and DuplicateSamples are the numbers of samples of generated requests
+	// that were moved back in time or duplicated with a different value.
+	OutOfOrderSamples int
+	DuplicateSamples  int
+}
+
+func newResponse() Response {
+	return Response{Response: *httpext.NewResponse()}
 }
 
 func (r *RemoteWrite) sample(c sobek.ConstructorCall) *sobek.Object {
@@ -177,16 +274,21 @@ func xtimeseries(labels map[string]string, samples []Sample) *Timeseries {
 }
 
 // StoreGenerated generates and stores synthetic time series data for load testing.
-func (c *Client) StoreGenerated(totalSeries, batches, batchSize, batch int64) (httpext.Response, error) {
-	ts, err := generateSeries(totalSeries, batches, batchSize, batch)
+func (c *Client) StoreGenerated(totalSeries, batches, batchSize, batch int64) (Response, error) {
+	state := c.vu.State()
+	if state == nil {
+		return newResponse(), errors.New("State is nil")
+	}
+
+	ts, err := generateSeries(c.newRand(state, 0, int(batch)), totalSeries, batches, batchSize, batch)
 	if err != nil {
-		return *httpext.NewResponse(), err
+		return newResponse(), err
 	}
 
-	return c.Store(ts)
+	return c.Store(ts, nil)
 }
 
-func generateSeries(totalSeries, batches, batchSize, batch int64) ([]Timeseries, error) {
+func generateSeries(r *rand.Rand, totalSeries, batches, batchSize, batch int64) ([]Timeseries, error) {
 	if totalSeries == 0 {
 		return nil, nil
 	}
@@ -199,8 +301,6 @@ func generateSeries(totalSeries, batches, batchSize, batch int64) ([]Timeseries,
 		return nil, errors.New("total_series must divide evenly into batches of size batch_size")
 	}
 
-	// #nosec G404 -- This is test data generation for load testing, not cryptographic use
-	r := rand.New(rand.NewSource(time.Now().Unix()))
 	series := make([]Timeseries, batchSize)
 	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
 
@@ -219,8 +319,8 @@ func generateSeries(totalSeries, batches, batchSize, batch int64) ([]Timeseries,
 		})
 
 		series[i] = Timeseries{
-			labels,
-			[]Sample{{r.Float64() * 100, timestamp}},
+			Labels:  labels,
+			Samples: []Sample{{Value: r.Float64() * 100, Timestamp: timestamp}},
 		}
 	}
 
@@ -243,15 +343,43 @@ func generateCardinalityLabels(totalSeries, seriesID int64) []Label {
 	return labels
 }
 
-// Store sends the provided time series to the Prometheus Remote Write endpoint.
-func (c *Client) Store(ts []Timeseries) (httpext.Response, error) {
+// Store sends the provided time series, and optionally the metadata of their metric families,
+// to the Prometheus Remote Write endpoint.
+func (c *Client) Store(ts []Timeseries, metadata []Metadata) (Response, error) {
+	start := time.Now()
 	batch := make([]prompb.TimeSeries, 0, len(ts))
 
 	for _, t := range ts {
 		batch = append(batch, FromTimeseriesToPrometheusTimeseries(t))
 	}
 
-	return c.store(batch)
+	md, err := toPrompbMetadata(metadata)
+	if err != nil {
+		return newResponse(), err
+	}
+
+	return c.store(batch, md, start)
+}
+
+// MarkStale sends a staleness marker for each of the series, telling the receiver that they ended.
+// The markers are timestamped with the given timestamp, or the current time if 0, and the samples
+// of the series are ignored.
+func (c *Client) MarkStale(ts []Timeseries, timestamp int64) (Response, error) {
+	start := time.Now()
+	if timestamp == 0 {
+		timestamp = start.UnixMilli()
+	}
+
+	batch := make([]prompb.TimeSeries, 0, len(ts))
+
+	for _, t := range ts {
+		batch = append(batch, FromTimeseriesToPrometheusTimeseries(Timeseries{
+			Labels:  t.Labels,
+			Samples: []Sample{{Timestamp: timestamp, Stale: true}},
+		}))
+	}
+
+	return c.store(batch, nil, start)
 }
 
 // ResponseCallback checks if the HTTP status code indicates success (2xx).
@@ -277,108 +405,108 @@ func FromTimeseriesToPrometheusTimeseries(ts Timeseries) prompb.TimeSeries {
 			sample.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
 		}
 
+		if sample.Stale {
+			sample.Value = staleNaN
+		}
+
 		samples = append(samples, prompb.Sample{
 			Value:     sample.Value,
 			Timestamp: sample.Timestamp,
 		})
 	}
 
-	return prompb.TimeSeries{
-		Labels:  labels,
-		Samples: samples,
-	}
-}
-
-// The only supported things are:
-// 1. replacing ${series_id} with the series_id provided.
-// 2. replacing ${series_id/<integer>} with the evaluation of that.
-// 3. if error in parsing return error.
-func compileTemplate(template string) (*labelGenerator, error) {
-	i := strings.Index(template, "${series_id")
-	if i == -1 {
-		return newIdentityLabelGenerator(template), nil
+	histograms := make([]prompb.Histogram, 0, len(ts.Histograms))
+	for _, h := range ts.Histograms {
+		histograms = append(histograms, h.toPrompb())
 	}
 
-	switch template[i+len("${series_id")] {
-	case '}':
-		return &labelGenerator{
-			AppendByte: func(b []byte, seriesID int) []byte {
-				b = append(b, template[:i]...)
-				//nolint:mnd // 10 is the base for decimal string conversion
-				b = strconv.AppendInt(b, int64(seriesID), 10)
-
-				return append(b, template[i+len("${series_id}"):]...)
-			},
-		}, nil
-	case '%':
-		end := strings.Index(template[i:], "}")
-		if end == -1 {
-			return nil, errors.New("no closing bracket in template")
+	exemplars := make([]prompb.Exemplar, 0, len(ts.Exemplars))
+	for _, e := range ts.Exemplars {
+		if e.Timestamp == 0 {
+			e.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
 		}
 
-		d, err := strconv.Atoi(template[i+len("${series_id%") : i+end])
-		if err != nil {
-			return nil, fmt.Errorf("can't parse divisor of the module operator %w", err)
+		exemplarLabels := make([]prompb.Label, 0, len(e.Labels))
+		for _, label := range e.Labels {
+			exemplarLabels = append(exemplarLabels, prompb.Label{
+				Name:  label.Name,
+				Value: label.Value,
+			})
 		}
 
-		possibleValues := make([][]byte, d)
-		// REVIEW TODO have an upper limit
-		for j := range d {
-			var b []byte
+		exemplars = append(exemplars, prompb.Exemplar{
+			Labels:    exemplarLabels,
+			Value:     e.Value,
+			Timestamp: e.Timestamp,
+		})
+	}
 
-			b = append(b, template[:i]...)
-			//nolint:mnd // 10 is the base for decimal string conversion
-			b = strconv.AppendInt(b, int64(j), 10)
-			possibleValues[j] = append(b, template[i+end+1:]...)
-		}
+	return prompb.TimeSeries{
+		Labels:     labels,
+		Samples:    samples,
+		Histograms: histograms,
+		Exemplars:  exemplars,
+	}
+}
+
+// compileTemplate compiles a label value template into a generator. Every ${...} placeholder of the
+// template is replaced with the evaluation of its expression, see compilePlaceholder.
+// If a placeholder can't be parsed an error is returned.
+func compileTemplate(template string) (*labelGenerator, error) {
+	var parts []func([]byte, int) []byte
+
+	vars := new(templateVars)
 
-		return &labelGenerator{
-			AppendByte: func(b []byte, seriesID int) []byte {
-				return append(b, possibleValues[seriesID%d]...)
-			},
-		}, nil
-	case '/':
-		end := strings.Index(template[i:], "}")
-		if end == -1 {
-			return nil, errors.New("no closing bracket in template")
+	for {
+		i := strings.Index(template, "${")
+		if i == -1 {
+			break
 		}
 
-		d, err := strconv.Atoi(template[i+len("${series_id/") : i+end])
+		// the literals are appended by the placeholders, along with their values
+		part, n, err := compilePlaceholder(template[:i], template[i:], vars)
 		if err != nil {
 			return nil, err
 		}
 
-		var memoize []byte
-
-		var memoizeValue int64
+		parts = append(parts, part)
+		template = template[i+n:]
+	}
 
-		return &labelGenerator{
-			AppendByte: func(b []byte, seriesID int) []byte {
-				value := int64(seriesID / d)
-				if memoize == nil || value != memoizeValue {
-					memoizeValue = value
-					memoize = memoize[:0]
-					memoize = append(memoize, template[:i]...)
-					//nolint:mnd // 10 is the base for decimal string conversion
-					memoize = strconv.AppendInt(memoize, value, 10)
-					memoize = append(memoize, template[i+end+1:]...)
-				}
+	if len(parts) == 0 {
+		return newIdentityLabelGenerator(template), nil
+	}
 
-				return append(b, memoize...)
-			},
-		}, nil
+	if len(parts) == 1 {
+		return &labelGenerator{AppendByte: parts[0], vars: vars}, nil
 	}
 
-	return nil, errors.New("unsupported template")
+	return &labelGenerator{
+		AppendByte: func(b []byte, seriesID int) []byte {
+			for _, part := range parts {
+				b = part(b, seriesID)
+			}
+
+			return b
+		},
+		vars: vars,
+	}, nil
+}
+
+func appendLiteral(t string) func([]byte, int) []byte {
+	return func(b []byte, _ int) []byte { return append(b, t...) }
 }
 
 type labelGenerator struct {
 	AppendByte func([]byte, int) []byte
+	// vars holds the values of the variables other than series_id that AppendByte reads.
+	vars *templateVars
 }
 
 func newIdentityLabelGenerator(t string) *labelGenerator {
 	return &labelGenerator{
-		AppendByte: func(b []byte, _ int) []byte { return append(b, t...) },
+		AppendByte: appendLiteral(t),
+		vars:       new(templateVars),
 	}
 }
 
@@ -386,12 +514,52 @@ func newIdentityLabelGenerator(t string) *labelGenerator {
 type labelTemplates struct {
 	compiledTemplates []compiledTemplate
 	labelValue        []byte
+
+	// source is kept to compile copies of the templates for concurrent generation.
+	source map[string]string
+	copies sync.Pool
+
+	// values is the state of the value generators of the series, shared with the copies.
+	values *seriesValues
+	// vars are the values of the variables of the templates for the next generated series.
+	vars templateVars
 }
 type compiledTemplate struct {
 	name      string
 	generator *labelGenerator
 }
 
+// setVars sets the values of the variables of the templates for the next generated series.
+func (template *labelTemplates) setVars(vars templateVars) {
+	template.vars = vars
+
+	for _, t := range template.compiledTemplates {
+		*t.generator.vars = vars
+	}
+}
+
+// TemplateOptions holds the optional settings of the template based store methods.
+type TemplateOptions struct {
+	// ExemplarEvery attaches a generated exemplar with a random trace_id to every Nth series,
+	// 0 disables exemplars.
+	ExemplarEvery int
+	// Metadata is sent along with the generated series, describing their metric families.
+	Metadata []Metadata
+	// Seed overrides the client's seed for this call.
+	Seed int64
+	// Value selects how the values of the series are generated, randomly by default.
+	Value ValueOptions
+	// Churn rotates a fraction of the series to new series IDs over time.
+	Churn ChurnOptions
+	// OutOfOrder moves a fraction of the samples back in time, and duplicates some of them.
+	OutOfOrder OutOfOrderOptions
+	// Samples is the number of samples of every series, 1 by default. They are Step apart and
+	// the last one is at the timestamp of the call, the way Prometheus batches the samples of
+	// a series when its shards fall behind.
+	Samples int
+	Step    string
+}
+
 func compileLabelTemplates(labelsTemplate map[string]string) (*labelTemplates, error) {
 	compiledTemplates := make([]compiledTemplate, len(labelsTemplate))
 	{
@@ -419,24 +587,80 @@ func compileLabelTemplates(labelsTemplate map[string]string) (*labelTemplates, e
 		compiledTemplates: compiledTemplates,
 		//nolint:mnd // 128 bytes is a reasonable initial buffer size for label values
 		labelValue: make([]byte, 128), // this is way more than necessary and it will grow if needed
+		source:     labelsTemplate,
+		values:     newSeriesValues(),
 	}, nil
 }
 
+// acquire returns a copy of the templates that is safe to use concurrently with the original.
+// The templates can't be shared between goroutines, as they reuse their buffers and memoize
+// the last generated values. The copy is given back with release.
+func (template *labelTemplates) acquire() (*labelTemplates, error) {
+	if t, ok := template.copies.Get().(*labelTemplates); ok {
+		return t, nil
+	}
+
+	t, err := compileLabelTemplates(template.source)
+	if err != nil {
+		return nil, err
+	}
+
+	t.values = template.values
+
+	return t, nil
+}
+
+func (template *labelTemplates) release(t *labelTemplates) {
+	template.copies.Put(t)
+}
+
 // StoreFromTemplates generates and stores time series data using label templates.
 func (c *Client) StoreFromTemplates(
 	minValue, maxValue int,
 	timestamp int64, minSeriesID, maxSeriesID int,
 	labelsTemplate map[string]string,
-) (httpext.Response, error) {
+	options TemplateOptions,
+) (Response, error) {
 	template, err := compileLabelTemplates(labelsTemplate)
 	if err != nil {
-		return *httpext.NewResponse(), err
+		return newResponse(), err
+	}
+
+	return c.StoreFromPrecompiledTemplates(minValue, maxValue, timestamp, minSeriesID, maxSeriesID, template, options)
+}
+
+// writeFor writes the TimeSeries fields of the series: its labels, then a sample for each of the values,
+// step milliseconds apart from timestamp on.
+func (template *labelTemplates) writeFor(
+	w *bytes.Buffer, values []float64, seriesID int, timestamp, step int64,
+) {
+	template.writeLabels(w, seriesID)
+
+	labelValue := template.labelValue[:10]
+
+	for i, value := range values {
+		labelValue = labelValue[:10]
+		labelValue[0] = 0x9
+		binary.LittleEndian.PutUint64(labelValue[1:9], math.Float64bits(value))
+		labelValue[9] = 0x10
+		// #nosec G115 -- timestamp is always positive milliseconds since Unix epoch
+		labelValue = protowire.AppendVarint(labelValue, uint64(timestamp+int64(i)*step))
+
+		n := len(labelValue)
+		labelValue = labelValue[:n+1]
+		labelValue[n] = 0x12
+		labelValue = protowire.AppendVarint(labelValue, uint64(n))
+		w.Write(labelValue[n:])
+		w.Write(labelValue[:n])
 	}
 
-	return c.StoreFromPrecompiledTemplates(minValue, maxValue, timestamp, minSeriesID, maxSeriesID, template)
+	template.labelValue = labelValue
+
+	// REVIEW TODO add error handling?
 }
 
-func (template *labelTemplates) writeFor(w *bytes.Buffer, value float64, seriesID int, timestamp int64) {
+// writeLabels writes the labels of the series as TimeSeries fields.
+func (template *labelTemplates) writeLabels(w *bytes.Buffer, seriesID int) {
 	labelValue := template.labelValue[:] //nolint:gocritic // reuse slice to avoid allocations
 	for _, template := range template.compiledTemplates {
 		labelValue = labelValue[:0]
@@ -464,22 +688,7 @@ func (template *labelTemplates) writeFor(w *bytes.Buffer, value float64, seriesI
 		w.Write(labelValue[n1:n2])
 	}
 
-	labelValue = labelValue[:10]
-	labelValue[0] = 0x9
-	binary.LittleEndian.PutUint64(labelValue[1:9], math.Float64bits(value))
-	labelValue[9] = 0x10
-	// #nosec G115 -- timestamp is always positive milliseconds since Unix epoch
-	labelValue = protowire.AppendVarint(labelValue, uint64(timestamp))
-
-	n := len(labelValue)
-	labelValue = labelValue[:n+1]
-	labelValue[n] = 0x12
-	labelValue = protowire.AppendVarint(labelValue, uint64(n))
-	w.Write(labelValue[n:])
-	w.Write(labelValue[:n])
 	template.labelValue = labelValue
-
-	// REVIEW TODO add error handling?
 }
 
 // StoreFromPrecompiledTemplates generates and stores time series data using precompiled label templates.
@@ -487,71 +696,167 @@ func (c *Client) StoreFromPrecompiledTemplates(
 	minValue, maxValue int,
 	timestamp int64, minSeriesID, maxSeriesID int,
 	template *labelTemplates,
-) (httpext.Response, error) {
+	options TemplateOptions,
+) (Response, error) {
 	state := c.vu.State()
 	if state == nil {
-		return *httpext.NewResponse(), errors.New("State is nil")
+		return newResponse(), errors.New("State is nil")
 	}
 
-	// #nosec G404 -- This is test data generation for load testing, not cryptographic use
-	r := rand.New(rand.NewSource(time.Now().Unix()))
+	start := time.Now()
+	r := c.newRand(state, options.Seed, minSeriesID)
+
+	template.setVars(newTemplateVars(c.vu.Context(), state))
 
-	buf, err := generateFromPrecompiledTemplates(r, minValue, maxValue, timestamp, minSeriesID, maxSeriesID, template)
+	buf, stats, err := generateFromPrecompiledTemplates(
+		r, minValue, maxValue, timestamp, minSeriesID, maxSeriesID, template, options,
+	)
 	if err != nil {
-		return *httpext.NewResponse(), err
+		return newResponse(), err
 	}
 
-	b := buf.Bytes()
-	//nolint:mnd // 9 is a heuristic compression ratio (actual ratio is between 1/9 and 1/10)
-	compressed := make([]byte, len(b)/9) // the general size is actually between 1/9 and 1/10th but this is closed enough
-	compressed = snappy.Encode(compressed, b)
-
-	res, err := c.send(state, compressed)
+	err = writeMetadata(buf, options.Metadata)
 	if err != nil {
-		return *httpext.NewResponse(), errors.Wrap(err, "remote-write request failed")
+		return newResponse(), err
 	}
 
-	res.Request.Body = ""
+	stats.start = start
 
-	return res, nil
+	return c.sendGenerated(state, buf, stats)
 }
 
-func (c *Client) store(batch []prompb.TimeSeries) (httpext.Response, error) {
+// sendGenerated sends a v1 request streamed by the template fast path, transcoded on the wire
+// for Remote Write 2.0.
+func (c *Client) sendGenerated(state *lib.State, buf *bytes.Buffer, stats requestStats) (Response, error) {
+	return c.sendNegotiated(state, stats,
+		func() ([]byte, error) { return transcodeToWriteV2(buf.Bytes()) },
+		func() ([]byte, error) { return buf.Bytes(), nil },
+	)
+}
+
+func (c *Client) store(
+	batch []prompb.TimeSeries, metadata []prompb.MetricMetadata, start time.Time,
+) (Response, error) {
 	// Required for k6 metrics
 	state := c.vu.State()
 	if state == nil {
-		return *httpext.NewResponse(), errors.New("State is nil")
+		return newResponse(), errors.New("State is nil")
 	}
 
-	req := prompb.WriteRequest{
+	req := &prompb.WriteRequest{
 		Timeseries: batch,
+		Metadata:   metadata,
+	}
+
+	return c.write(state, req, statsOf(req, start))
+}
+
+// write marshals the request with the negotiated protocol and sends it.
+func (c *Client) write(state *lib.State, req *prompb.WriteRequest, stats requestStats) (Response, error) {
+	return c.sendNegotiated(state, stats,
+		func() ([]byte, error) { return proto.Marshal(protoadapt.MessageV2Of(toWriteV2Request(req))) },
+		func() ([]byte, error) { return proto.Marshal(protoadapt.MessageV2Of(req)) },
+	)
+}
+
+// sendNegotiated encodes the request with the negotiated protocol and sends it. When a Remote Write 2.0
+// request is rejected with 415 Unsupported Media Type, it is sent again as a 1.0 request the way
+// Prometheus falls back for receivers that don't support the newer protocol, and so are all the
+// following requests of the client.
+func (c *Client) sendNegotiated(
+	state *lib.State, stats requestStats, encodeV2, encodeV1 func() ([]byte, error),
+) (Response, error) {
+	if c.protocol() == protocolV2 {
+		data, err := encodeV2()
+		if err != nil {
+			return newResponse(), errors.Wrap(err, "failed to encode remote-write request")
+		}
+
+		res, err := c.sendEncoded(state, protocolV2, data, stats)
+		if err != nil || !unsupportedV2(protocolV2, res) {
+			return res, err
+		}
+
+		c.downgraded.Store(true)
+
+		stats.start = time.Now()
+	}
+
+	data, err := encodeV1()
+	if err != nil {
+		return newResponse(), errors.Wrap(err, "failed to encode remote-write request")
+	}
+
+	return c.sendEncoded(state, protocolV1, data, stats)
+}
+
+// unsupportedV2 reports whether the request was a Remote Write 2.0 request rejected by a receiver
+// that doesn't support the protocol, in which case it's sent again as a 1.0 request.
+func unsupportedV2(protocol string, res Response) bool {
+	return protocol == protocolV2 && res.Status == http.StatusUnsupportedMediaType
+}
+
+// protocol returns the protocol of the next requests: the configured one, unless the receiver
+// rejected Remote Write 2.0 requests.
+func (c *Client) protocol() string {
+	if c.downgraded.Load() {
+		return protocolV1
+	}
+
+	return c.cfg.Protocol
+}
+
+// sendEncoded compresses the marshalled request and sends it.
+func (c *Client) sendEncoded(state *lib.State, protocol string, data []byte, stats requestStats) (Response, error) {
+	enc, err := c.getEncoder()
+	if err != nil {
+		return newResponse(), err
 	}
 
-	data, err := proto.Marshal(protoadapt.MessageV2Of(&req))
+	compressed, err := enc.compress(data)
 	if err != nil {
-		return *httpext.NewResponse(), errors.Wrap(err, "failed to marshal remote-write request")
+		return newResponse(), err
 	}
 
-	compressed := snappy.Encode(nil, data)
+	encodeDuration := time.Since(stats.start)
 
-	res, err := c.send(state, compressed)
+	res, err := c.sendWithRetry(state, protocol, enc.contentEncoding, compressed)
 	if err != nil {
-		return *httpext.NewResponse(), errors.Wrap(err, "remote-write request failed")
+		return newResponse(), errors.Wrap(err, "remote-write request failed")
 	}
 
 	res.Request.Body = ""
+	res.OutOfOrderSamples = stats.outOfOrder
+	res.DuplicateSamples = stats.duplicates
+
+	// the metrics of a rejected 2.0 request are the ones of the 1.0 request sent in its place
+	if !unsupportedV2(protocol, res) {
+		c.pushMetrics(state, stats, ResponseCallback(res.Status), len(data), len(compressed), encodeDuration)
+	}
 
 	return res, nil
 }
 
+// getEncoder returns the compression encoder of the client, creating it on first use
+// for clients that weren't built by the JS constructor.
+func (c *Client) getEncoder() (*encoder, error) {
+	c.encoderOnce.Do(func() {
+		if c.encoder == nil {
+			c.encoder, c.encoderErr = newEncoder(c.cfg.Compression)
+		}
+	})
+
+	return c.encoder, c.encoderErr
+}
+
 // send sends a batch of samples to the HTTP endpoint, the request is the proto marshalled
-// and encoded bytes.
-func (c *Client) send(state *lib.State, req []byte) (httpext.Response, error) {
-	httpResp := httpext.NewResponse()
+// and encoded bytes. A non-zero attempt is added as a tag of the request's metrics.
+func (c *Client) send(state *lib.State, protocol, contentEncoding string, req []byte, attempt int) (Response, error) {
+	httpResp := newResponse()
 
 	r, err := http.NewRequestWithContext(c.vu.Context(), http.MethodPost, c.cfg.Url, nil)
 	if err != nil {
-		return *httpResp, err
+		return httpResp, err
 	}
 
 	for k, v := range c.cfg.Headers {
@@ -563,26 +868,51 @@ func (c *Client) send(state *lib.State, req []byte) (httpext.Response, error) {
 	}
 
 	// explicit config overwrites any previously set matching headers
-	r.Header.Add("Content-Encoding", "snappy")
-	r.Header.Set("Content-Type", "application/x-protobuf")
+	if contentEncoding != "" {
+		r.Header.Add("Content-Encoding", contentEncoding)
+	}
+
+	r.Header.Set("Content-Type", contentTypes[protocol])
 	r.Header.Set("User-Agent", c.cfg.UserAgent)
-	r.Header.Set("X-Prometheus-Remote-Write-Version", "0.0.2")
+	r.Header.Set("X-Prometheus-Remote-Write-Version", versionHeaders[protocol])
 
 	if c.cfg.TenantName != "" {
 		r.Header.Set("X-Scope-Orgid", c.cfg.TenantName)
 	}
 
-	duration, err := str2duration.ParseDuration(c.cfg.Timeout)
+	// credentials embedded in the URL are kept out of the URL tag and of the response
+	if user := r.URL.User; user != nil {
+		if r.Header.Get("Authorization") == "" {
+			password, _ := user.Password()
+			r.SetBasicAuth(user.Username(), password)
+		}
+
+		r.URL.User = nil
+	}
+
+	auth, err := c.getAuthorizer()
 	if err != nil {
-		return *httpResp, err
+		return httpResp, err
 	}
 
-	u, err := url.Parse(c.cfg.Url)
+	err = auth.authorize(r, req, state.Transport)
 	if err != nil {
-		return *httpResp, err
+		return httpResp, err
 	}
 
-	url, _ := httpext.NewURL(c.cfg.Url, u.Host+u.Path)
+	duration, err := str2duration.ParseDuration(c.cfg.Timeout)
+	if err != nil {
+		return httpResp, err
+	}
+
+	u := r.URL
+
+	url, _ := httpext.NewURL(u.String(), u.Host+u.Path)
+
+	tagsAndMeta := state.Tags.GetCurrentValues()
+	if attempt > 0 {
+		tagsAndMeta.SetTag("attempt", strconv.Itoa(attempt))
+	}
 
 	response, err := httpext.MakeRequest(c.vu.Context(), state, &httpext.ParsedHTTPRequest{
 		URL:              &url,
@@ -592,59 +922,196 @@ func (c *Client) send(state *lib.State, req []byte) (httpext.Response, error) {
 		Redirects:        state.Options.MaxRedirects,
 		Timeout:          duration,
 		ResponseCallback: ResponseCallback,
-		TagsAndMeta:      state.Tags.GetCurrentValues(),
+		TagsAndMeta:      tagsAndMeta,
 	})
 	if err != nil {
-		return *httpResp, err
+		return httpResp, requestError{err}
 	}
 
-	return *response, err
+	httpResp.Response = *response
+	httpResp.SamplesWritten = writtenHeader(response, "X-Prometheus-Remote-Write-Samples-Written")
+	httpResp.HistogramsWritten = writtenHeader(response, "X-Prometheus-Remote-Write-Histograms-Written")
+	httpResp.ExemplarsWritten = writtenHeader(response, "X-Prometheus-Remote-Write-Exemplars-Written")
+
+	return httpResp, nil
 }
 
+// writtenHeader parses one of the Remote Write 2.0 written-count response headers,
+// returning 0 when the receiver didn't send it.
+func writtenHeader(res *httpext.Response, name string) int64 {
+	n, err := strconv.ParseInt(res.Headers[name], 10, 64) //nolint:mnd // base 10, 64 bit counters
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// generateFromPrecompiledTemplates streams a v1 request with the samples of every series of the range,
+// along with the duplicated samples and the staleness markers of the series retired by churn.
 func generateFromPrecompiledTemplates(
 	r *rand.Rand,
 	minValue, maxValue int,
 	timestamp int64, minSeriesID, maxSeriesID int,
 	template *labelTemplates,
-) (*bytes.Buffer, error) {
-	//nolint:mnd // 1024 bytes is a reasonable initial buffer size
-	bigB := make([]byte, 1024)
+	options TemplateOptions,
+) (*bytes.Buffer, requestStats, error) {
 	buf := new(bytes.Buffer)
-	buf.Reset()
-
 	tsBuf := new(bytes.Buffer)
-	bigB[0] = 0xa
 
-	template.writeFor(tsBuf, valueBetween(r, minValue, maxValue), minSeriesID, timestamp)
+	next, err := options.Value.compile(template.values, minValue, maxValue)
+	if err != nil {
+		return nil, requestStats{}, err
+	}
 
-	bigB = protowire.AppendVarint(bigB[:1], uint64(tsBuf.Len())) // #nosec G115 -- buffer Len() is always non-negative
-	buf.Write(bigB)
+	ch, err := options.Churn.compile(minSeriesID, maxSeriesID, timestamp, template.vars.iteration)
+	if err != nil {
+		return nil, requestStats{}, err
+	}
 
-	_, err := tsBuf.WriteTo(buf)
+	ooo, err := options.OutOfOrder.compile()
 	if err != nil {
-		return nil, err
+		return nil, requestStats{}, err
 	}
 
-	//nolint:mnd // 2 is a heuristic padding factor for buffer growth
-	buf.Grow((buf.Len() + 2) * (maxSeriesID - minSeriesID)) // heuristics to try to get big enough buffer in one go
+	samples, step, err := options.samples()
+	if err != nil {
+		return nil, requestStats{}, err
+	}
 
-	for seriesID := minSeriesID + 1; seriesID < maxSeriesID; seriesID++ {
-		tsBuf.Reset()
+	template.values.mu.Lock()
+	defer template.values.mu.Unlock()
 
-		bigB[0] = 0xa
+	var exemplar []byte
 
-		template.writeFor(tsBuf, valueBetween(r, minValue, maxValue), seriesID, timestamp)
+	// the first series is always generated, even for an empty range
+	series := max(maxSeriesID-minSeriesID, 1)
+	stats := requestStats{series: series, samples: series * samples}
 
-		bigB = protowire.AppendVarint(bigB[:1], uint64(tsBuf.Len())) // #nosec G115 -- buffer Len() is always non-negative
-		buf.Write(bigB)
+	// the samples of a series end at timestamp
+	first := timestamp - int64(samples-1)*step
+	values := make([]float64, samples)
 
-		_, err = tsBuf.WriteTo(buf)
-		if err != nil {
-			return nil, err
+	var duplicates []float64
+	if ooo.duplicates > 0 {
+		duplicates = make([]float64, samples)
+	}
+
+	for i := range series {
+		seriesID := ch.id(minSeriesID + i)
+
+		for j := range values {
+			values[j] = next(r, seriesID, first+int64(j)*step)
+		}
+
+		start, delayed := ooo.timestamp(r, first)
+		if delayed {
+			stats.outOfOrder += samples
+		}
+
+		last := start + int64(samples-1)*step
+		exemplar = options.appendExemplar(exemplar[:0], r, seriesID, values[samples-1], last)
+
+		template.appendSeries(buf, tsBuf, values, seriesID, start, step, exemplar)
+
+		if ooo.duplicate(r) {
+			for j, value := range values {
+				duplicates[j] = value + 1
+			}
+
+			template.appendSeries(buf, tsBuf, duplicates, seriesID, start, step, nil)
+
+			stats.series++
+			stats.samples += samples
+			stats.duplicates += samples
+		}
+
+		if i == 0 {
+			//nolint:mnd // 2 is a heuristic padding factor for buffer growth
+			buf.Grow((buf.Len() + 2) * series) // heuristics to try to get big enough buffer in one go
 		}
 	}
 
-	return buf, nil
+	retired := ch.rotate(template.values, minSeriesID)
+	if ch.staleMarkers {
+		stale := []float64{staleNaN}
+
+		for _, seriesID := range retired {
+			template.appendSeries(buf, tsBuf, stale, seriesID, timestamp, 0, nil)
+		}
+
+		stats.series += len(retired)
+		stats.samples += len(retired)
+	}
+
+	return buf, stats, nil
+}
+
+// appendSeries appends a TimeSeries field with the samples of the series, and its exemplar, to buf.
+func (template *labelTemplates) appendSeries(
+	buf, tsBuf *bytes.Buffer, values []float64, seriesID int, timestamp, step int64, exemplar []byte,
+) {
+	tsBuf.Reset()
+	template.writeFor(tsBuf, values, seriesID, timestamp, step)
+	tsBuf.Write(exemplar)
+
+	var header [1 + binary.MaxVarintLen64]byte
+
+	header[0] = 0xa
+
+	buf.Write(protowire.AppendVarint(header[:1], uint64(tsBuf.Len()))) // #nosec G115 -- buffer Len() is always non-negative
+	buf.Write(tsBuf.Bytes())
+}
+
+// samples returns the number of samples of every series, and the step between them in milliseconds.
+func (options TemplateOptions) samples() (int, int64, error) {
+	if options.Samples < 0 {
+		return 0, 0, errors.New("the number of samples per series can't be negative")
+	}
+
+	if options.Samples <= 1 {
+		return 1, 0, nil
+	}
+
+	step, err := parseValueDuration(options.Step, 0)
+	if err != nil || step < time.Millisecond {
+		return 0, 0, errors.New("several samples per series expect a step of at least 1ms")
+	}
+
+	return options.Samples, step.Milliseconds(), nil
+}
+
+// appendExemplar appends an exemplars TimeSeries field to b when the series is one of every
+// ExemplarEvery series. The exemplar shares the sample's value and timestamp and carries a
+// random trace_id label.
+func (options TemplateOptions) appendExemplar(
+	b []byte, r *rand.Rand, seriesID int, value float64, timestamp int64,
+) []byte {
+	if options.ExemplarEvery <= 0 || seriesID%options.ExemplarEvery != 0 {
+		return b
+	}
+
+	var traceID [16]byte
+
+	binary.BigEndian.PutUint64(traceID[:8], r.Uint64())
+	binary.BigEndian.PutUint64(traceID[8:], r.Uint64())
+
+	label := protowire.AppendTag(nil, 1, protowire.BytesType)
+	label = protowire.AppendString(label, "trace_id")
+	label = protowire.AppendTag(label, 2, protowire.BytesType) //nolint:mnd // Label value field
+	label = protowire.AppendBytes(label, hex.AppendEncode(nil, traceID[:]))
+
+	exemplar := protowire.AppendTag(nil, 1, protowire.BytesType)
+	exemplar = protowire.AppendBytes(exemplar, label)
+	exemplar = protowire.AppendTag(exemplar, 2, protowire.Fixed64Type) //nolint:mnd // Exemplar value field
+	exemplar = protowire.AppendFixed64(exemplar, math.Float64bits(value))
+	exemplar = protowire.AppendTag(exemplar, 3, protowire.VarintType) //nolint:mnd // Exemplar timestamp field
+	// #nosec G115 -- timestamp is always positive milliseconds since Unix epoch
+	exemplar = protowire.AppendVarint(exemplar, uint64(timestamp))
+
+	b = protowire.AppendTag(b, 3, protowire.BytesType) //nolint:mnd // TimeSeries exemplars field
+
+	return protowire.AppendBytes(b, exemplar)
 }
 
 func valueBetween(r *rand.Rand, minVal, maxVal int) float64 {
@@ -3,11 +3,14 @@ package remotewrite
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"math/rand"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
@@ -29,8 +32,35 @@ func TestEvaluateTemplate(t *testing.T) {
 		{template: "something ${series_id/6 else", expectedError: "closing bracket"},
 		{template: "something ${series_id%6} else", value: 12, result: "something 0 else"},
 		{template: "something ${series_id%6 else", expectedError: "closing bracket"},
-		{template: "something ${series_id*6} else", expectedError: "unsupported template"},
+		{template: "something ${series_id*6} else", value: 12, result: "something 72 else"},
+		{template: "something ${series_id^6} else", expectedError: "unsupported template"},
 		{template: "something else", result: "something else"},
+		{template: "${series_id/10}-${series_id%3}", value: 25, result: "2-1"},
+		{template: "a${series_id}b${series_id/2}c${series_id%5}d", value: 7, result: "a7b3c2d"},
+		{template: "${series_id}${series_id}", value: 7, result: "77"},
+		{template: "pod-${series_id%[a,b]}-x", value: 3, result: "pod-b-x"},
+		{template: "${series_id} ${series_id/6 else", expectedError: "closing bracket"},
+		{template: "something ${series_id", expectedError: "unsupported template"},
+		{template: "${(series_id/10)%5}", value: 123, result: "2"},
+		{template: "${series_id/10%5}", value: 123, result: "2"},
+		{template: "${ series_id * 2 + 100 }", value: 7, result: "114"},
+		{template: "${series_id-10}", value: 7, result: "-3"},
+		{template: "${-series_id+(2*(3+4))}", value: 7, result: "7"},
+		{template: "${series_id:06}", value: 42, result: "000042"},
+		{template: "${series_id-50:04}", value: 42, result: "-008"},
+		{template: "${series_id:x}", value: 255, result: "ff"},
+		{template: "${series_id*16:08x}", value: 255, result: "00000ff0"},
+		{template: "${series_id%16:02x}", value: 31, result: "0f"},
+		{template: "${hash(series_id)}", value: 1, result: strconv.FormatInt(int64(mix64(1)>>1), 10)},
+		{template: "${hash(series_id)%1000:03}", value: 1, result: fmt.Sprintf("%03d", int64(mix64(1)>>1)%1000)},
+		{template: "${hash(1+2)}", value: 7, result: strconv.FormatInt(int64(mix64(3)>>1), 10)},
+		{template: "${series_id:6}", expectedError: "must start with 0"},
+		{template: "${series_id:099}", expectedError: "can't exceed"},
+		{template: "${series_id/0}", expectedError: "positive integer"},
+		{template: "${series_id%0}", expectedError: "positive integer"},
+		{template: "${hash(series_id, 1)}", expectedError: "single argument"},
+		{template: "${pod}", expectedError: "unknown variable"},
+		{template: "${(series_id}", expectedError: "unsupported template"},
 	}
 	for _, testcase := range testcases {
 		t.Run(fmt.Sprintf("template=%q,value=%d", testcase.template, testcase.value), func(t *testing.T) {
@@ -52,6 +82,34 @@ func TestEvaluateTemplate(t *testing.T) {
 	}
 }
 
+func TestCompileTemplateMemoized(t *testing.T) {
+	t.Parallel()
+
+	// the literals are memoized along with the values of the placeholders
+	compiled, err := compileTemplate("a-${series_id/10}-b-${(series_id-20)%3}-c-${series_id*2}")
+	require.NoError(t, err)
+
+	for seriesID := range 40 {
+		expected := fmt.Sprintf("a-%d-b-%d-c-%d", seriesID/10, (seriesID-20)%3, seriesID*2)
+		require.Equal(t, expected, string(compiled.AppendByte(nil, seriesID)))
+	}
+}
+
+//nolint:paralleltest // allocations are counted process wide
+func TestCompileTemplateAllocations(t *testing.T) {
+	compiled, err := compileTemplate("pod-${series_id/10}-${series_id%3}-${series_id}-${series_id%[a,b]}")
+	require.NoError(t, err)
+
+	b := make([]byte, 0, 64)
+	seriesID := 0
+
+	allocs := testing.AllocsPerRun(100, func() {
+		b = compiled.AppendByte(b[:0], seriesID)
+		seriesID++
+	})
+	require.Zero(t, allocs)
+}
+
 func TestGenerateFromTemplates(t *testing.T) {
 	t.Parallel()
 
@@ -160,9 +218,9 @@ func TestGenerateFromTemplates(t *testing.T) {
 			compiled, err := compileLabelTemplates(tt.args.labelsTemplate)
 			require.NoError(t, err)
 
-			buf, err := generateFromPrecompiledTemplates(
+			buf, _, err := generateFromPrecompiledTemplates(
 				r, tt.args.minValue, tt.args.maxValue, tt.args.timestamp,
-				tt.args.minSeriesID, tt.args.maxSeriesID, compiled,
+				tt.args.minSeriesID, tt.args.maxSeriesID, compiled, TemplateOptions{},
 			)
 			require.NoError(t, err)
 
@@ -205,6 +263,137 @@ func TestGenerateFromTemplates(t *testing.T) {
 	}
 }
 
+func TestGenerateFromTemplatesExemplars(t *testing.T) {
+	t.Parallel()
+
+	// #nosec G404 -- Using math/rand in test code, cryptographic randomness not required
+	r := rand.New(rand.NewSource(1))
+	compiled, err := compileLabelTemplates(map[string]string{"__name__": "k6_generated_metric_${series_id}"})
+	require.NoError(t, err)
+
+	buf, _, err := generateFromPrecompiledTemplates(r, 1, 10, 1000, 0, 10, compiled, TemplateOptions{ExemplarEvery: 3})
+	require.NoError(t, err)
+
+	req := new(prompb.WriteRequest)
+	require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+	require.Len(t, req.Timeseries, 10)
+
+	for seriesID, ts := range req.Timeseries {
+		if seriesID%3 != 0 {
+			require.Empty(t, ts.Exemplars)
+
+			continue
+		}
+
+		require.Len(t, ts.Exemplars, 1)
+
+		e := ts.Exemplars[0]
+		require.Equal(t, "trace_id", e.Labels[0].Name)
+		require.Len(t, e.Labels[0].Value, 32)
+		require.Equal(t, ts.Samples[0].Value, e.Value)
+		require.Equal(t, int64(1000), e.Timestamp)
+	}
+}
+
+func TestGenerateFromTemplatesSamples(t *testing.T) {
+	t.Parallel()
+
+	compiled, err := compileLabelTemplates(map[string]string{"__name__": "metric_${series_id}"})
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic test data
+
+	buf, stats, err := generateFromPrecompiledTemplates(r, 1, 10, 100_000, 0, 5, compiled, TemplateOptions{
+		Samples:       4,
+		Step:          "15s",
+		ExemplarEvery: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, requestStats{series: 5, samples: 20}, stats)
+
+	req := new(prompb.WriteRequest)
+	require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+	require.Len(t, req.Timeseries, 5)
+
+	for i, ts := range req.Timeseries {
+		require.Equal(t, []prompb.Label{{Name: "__name__", Value: "metric_" + strconv.Itoa(i)}}, ts.Labels)
+		require.Len(t, ts.Samples, 4)
+
+		for j, sample := range ts.Samples {
+			require.Equal(t, int64(55_000+15_000*j), sample.Timestamp)
+		}
+
+		// the exemplar goes with the last sample
+		if i%2 == 0 {
+			require.Len(t, ts.Exemplars, 1)
+			require.Equal(t, int64(100_000), ts.Exemplars[0].Timestamp)
+			require.InDelta(t, ts.Samples[3].Value, ts.Exemplars[0].Value, 0)
+		}
+	}
+
+	_, _, err = generateFromPrecompiledTemplates(r, 1, 10, 100_000, 0, 5, compiled, TemplateOptions{Samples: 2})
+	require.ErrorContains(t, err, "step")
+}
+
+func TestFromTimeseriesToPrometheusTimeseriesExemplars(t *testing.T) {
+	t.Parallel()
+
+	got := FromTimeseriesToPrometheusTimeseries(Timeseries{
+		Labels:  []Label{{Name: "__name__", Value: "metric"}},
+		Samples: []Sample{{Value: 1, Timestamp: 1000}},
+		Exemplars: []Exemplar{{
+			Labels:    []Label{{Name: "trace_id", Value: "abc"}},
+			Value:     1,
+			Timestamp: 1000,
+		}},
+	})
+
+	require.Equal(t, []prompb.Exemplar{{
+		Labels:    []prompb.Label{{Name: "trace_id", Value: "abc"}},
+		Value:     1,
+		Timestamp: 1000,
+	}}, got.Exemplars)
+}
+
+func TestFromTimeseriesToPrometheusTimeseriesStale(t *testing.T) {
+	t.Parallel()
+
+	got := FromTimeseriesToPrometheusTimeseries(Timeseries{
+		Labels:  []Label{{Name: "__name__", Value: "metric"}},
+		Samples: []Sample{{Value: math.NaN(), Timestamp: 1000}, {Value: 1, Timestamp: 2000, Stale: true}},
+	})
+
+	require.Len(t, got.Samples, 2)
+	require.False(t, value.IsStaleNaN(got.Samples[0].Value))
+	require.Equal(t, value.StaleNaN, math.Float64bits(got.Samples[1].Value))
+}
+
+func TestMarkStale(t *testing.T) {
+	t.Parallel()
+
+	s, requests := newRecordingServer(t)
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	_, err := c.MarkStale([]Timeseries{
+		{Labels: []Label{{Name: "__name__", Value: "a"}}, Samples: []Sample{{Value: 1, Timestamp: 1000}}},
+		{Labels: []Label{{Name: "__name__", Value: "b"}}},
+	}, 5000)
+	require.NoError(t, err)
+
+	reqs := requests()
+	require.Len(t, reqs, 1)
+	require.Len(t, reqs[0].Timeseries, 2)
+
+	for _, ts := range reqs[0].Timeseries {
+		require.Len(t, ts.Samples, 1)
+		require.Equal(t, int64(5000), ts.Samples[0].Timestamp)
+		require.Equal(t, value.StaleNaN, math.Float64bits(ts.Samples[0].Value))
+	}
+}
+
 // this test that the prompb stream marshalling implementation produces the same result as the upstream one.
 func TestStreamEncoding(t *testing.T) {
 	t.Parallel()
@@ -334,7 +523,7 @@ func TestStreamEncoding(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	buf, err := generateFromPrecompiledTemplates(r, minValue, maxValue, timestamp, 15, 22, template)
+	buf, _, err := generateFromPrecompiledTemplates(r, minValue, maxValue, timestamp, 15, 22, template, TemplateOptions{})
 	require.NoError(t, err)
 
 	b := buf.Bytes()
@@ -357,11 +546,13 @@ func BenchmarkWriteFor(b *testing.B) {
 		"cardinality_1e9": "${series_id/1000000000}",               // Each value of this label will match 1000000000 series.
 	})
 	require.NoError(b, err)
-	template.writeFor(tsBuf, 15, 15, 234)
+
+	values := []float64{15}
+	template.writeFor(tsBuf, values, 15, 234, 0)
 	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := range b.N {
-		template.writeFor(tsBuf, 15, i, 234)
+		template.writeFor(tsBuf, values, i, 234, 0)
 	}
 }
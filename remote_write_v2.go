@@ -0,0 +1,404 @@
+package remotewrite
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	protocolV1 = "v1"
+	protocolV2 = "v2"
+)
+
+//nolint:gochecknoglobals // lookup tables for the protocol dependent request headers
+var (
+	contentTypes = map[string]string{
+		protocolV1: "application/x-protobuf",
+		protocolV2: "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+	}
+	versionHeaders = map[string]string{
+		protocolV1: "0.0.2",
+		protocolV2: "2.0.0",
+	}
+)
+
+// toWriteV2Request converts a Remote Write 1.0 request into its 2.0 equivalent,
+// interning every label name and value into the request's symbol table.
+// Metric metadata is attached to the series of the metric family it describes.
+func toWriteV2Request(req *prompb.WriteRequest) *writev2.Request {
+	symbols := writev2.NewSymbolTable()
+	series := make([]writev2.TimeSeries, 0, len(req.Timeseries))
+
+	metadata := make(map[string]prompb.MetricMetadata, len(req.Metadata))
+	for _, m := range req.Metadata {
+		metadata[m.MetricFamilyName] = m
+	}
+
+	for _, ts := range req.Timeseries {
+		var name string
+
+		refs := make([]uint32, 0, 2*len(ts.Labels)) //nolint:mnd // a name and a value reference per label
+		for _, l := range ts.Labels {
+			refs = append(refs, symbols.Symbolize(l.Name), symbols.Symbolize(l.Value))
+
+			if l.Name == "__name__" {
+				name = l.Value
+			}
+		}
+
+		samples := make([]writev2.Sample, 0, len(ts.Samples))
+		for _, s := range ts.Samples {
+			samples = append(samples, writev2.Sample{Value: s.Value, Timestamp: s.Timestamp})
+		}
+
+		histograms := make([]writev2.Histogram, 0, len(ts.Histograms))
+		for _, h := range ts.Histograms {
+			histograms = append(histograms, toWriteV2Histogram(h))
+		}
+
+		exemplars := make([]writev2.Exemplar, 0, len(ts.Exemplars))
+		for _, e := range ts.Exemplars {
+			exemplarRefs := make([]uint32, 0, 2*len(e.Labels)) //nolint:mnd // a name and a value reference per label
+			for _, l := range e.Labels {
+				exemplarRefs = append(exemplarRefs, symbols.Symbolize(l.Name), symbols.Symbolize(l.Value))
+			}
+
+			exemplars = append(exemplars, writev2.Exemplar{
+				LabelsRefs: exemplarRefs,
+				Value:      e.Value,
+				Timestamp:  e.Timestamp,
+			})
+		}
+
+		var md writev2.Metadata
+		if m, ok := lookupMetadata(metadata, name); ok {
+			md = writev2.Metadata{
+				Type:    writev2.Metadata_MetricType(m.Type),
+				HelpRef: symbols.Symbolize(m.Help),
+				UnitRef: symbols.Symbolize(m.Unit),
+			}
+		}
+
+		series = append(series, writev2.TimeSeries{
+			LabelsRefs: refs,
+			Samples:    samples,
+			Histograms: histograms,
+			Exemplars:  exemplars,
+			Metadata:   md,
+		})
+	}
+
+	return &writev2.Request{
+		Symbols:    symbols.Symbols(),
+		Timeseries: series,
+	}
+}
+
+func toWriteV2Histogram(h prompb.Histogram) writev2.Histogram {
+	if h.IsFloatHistogram() {
+		return writev2.FromFloatHistogram(h.Timestamp, h.ToFloatHistogram())
+	}
+
+	return writev2.FromIntHistogram(h.Timestamp, h.ToIntHistogram())
+}
+
+// lookupMetadata finds the metadata of the metric family a series belongs to, either by its exact
+// name or by its name without the suffixes of the classic histogram, summary and counter series.
+func lookupMetadata(metadata map[string]prompb.MetricMetadata, name string) (prompb.MetricMetadata, bool) {
+	if m, ok := metadata[name]; ok {
+		return m, true
+	}
+
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_total", "_created"} {
+		if family, ok := strings.CutSuffix(name, suffix); ok {
+			if m, ok := metadata[family]; ok {
+				return m, true
+			}
+		}
+	}
+
+	return prompb.MetricMetadata{}, false
+}
+
+// writeV2Transcoders keeps the symbol tables and the buffers of the transcoders across requests.
+var writeV2Transcoders = sync.Pool{ //nolint:gochecknoglobals // buffers reused across requests
+	New: func() any {
+		return &writeV2Transcoder{symbols: make(map[string]uint32)}
+	},
+}
+
+// writeV2Transcoder transcodes Remote Write 1.0 requests into their 2.0 equivalent on the wire.
+type writeV2Transcoder struct {
+	symbols map[string]uint32
+	table   []string
+
+	refs       []uint32
+	samples    []byte
+	histograms []byte
+	exemplars  []byte
+	series     []byte
+}
+
+// transcodeToWriteV2 transcodes a Remote Write 1.0 request streamed by the template fast path into
+// its 2.0 equivalent, without decoding it into messages. Samples and histograms have the same wire
+// format in both protocols and are copied as they are, only the labels are replaced by references
+// to the symbol table. Metric metadata is attached to the series of the metric family it describes.
+func transcodeToWriteV2(req []byte) ([]byte, error) {
+	t, _ := writeV2Transcoders.Get().(*writeV2Transcoder)
+	defer writeV2Transcoders.Put(t)
+
+	clear(t.symbols)
+	t.table = t.table[:0]
+	t.symbolize(nil)
+
+	metadata, err := transcodedMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(req))
+
+	err = forEachField(req, func(num protowire.Number, field, value []byte) error {
+		if num != 1 { // WriteRequest timeseries field, the metadata was read beforehand
+			return nil
+		}
+
+		var err error
+
+		out, err = t.appendSeries(out, value, metadata)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the symbols follow the series that reference them, fields can come in any order
+	for _, symbol := range t.table {
+		out = protowire.AppendTag(out, 4, protowire.BytesType) //nolint:mnd // Request symbols field
+		out = protowire.AppendString(out, symbol)
+	}
+
+	return out, nil
+}
+
+// appendSeries appends the 2.0 TimeSeries field of the 1.0 TimeSeries message ts to out.
+func (t *writeV2Transcoder) appendSeries(
+	out, ts []byte, metadata map[string]prompb.MetricMetadata,
+) ([]byte, error) {
+	t.refs = t.refs[:0]
+	t.samples = t.samples[:0]
+	t.histograms = t.histograms[:0]
+	t.exemplars = t.exemplars[:0]
+
+	var name []byte
+
+	err := forEachField(ts, func(num protowire.Number, field, value []byte) error {
+		switch num {
+		case 1: // labels
+			labelName, labelValue, err := consumeLabel(value)
+			if err != nil {
+				return err
+			}
+
+			t.refs = append(t.refs, t.symbolize(labelName), t.symbolize(labelValue))
+
+			if string(labelName) == "__name__" {
+				name = labelValue
+			}
+		case 2: // samples, the same field in both protocols
+			t.samples = append(t.samples, field...)
+		case 3: //nolint:mnd // exemplars
+			var err error
+
+			t.exemplars, err = t.appendExemplar(t.exemplars, value)
+			if err != nil {
+				return err
+			}
+		case 4: //nolint:mnd // histograms, the third field of 2.0 series
+			t.histograms = protowire.AppendTag(t.histograms, 3, protowire.BytesType) //nolint:mnd // TimeSeries histograms field
+			t.histograms = protowire.AppendBytes(t.histograms, value)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.series = appendRefs(t.series[:0], t.refs)
+	t.series = append(t.series, t.samples...)
+	t.series = append(t.series, t.histograms...)
+	t.series = append(t.series, t.exemplars...)
+
+	if len(metadata) > 0 {
+		if m, ok := lookupMetadata(metadata, string(name)); ok {
+			var md []byte
+
+			md = protowire.AppendTag(md, 1, protowire.VarintType)
+			md = protowire.AppendVarint(md, uint64(m.Type))       // #nosec G115 -- metric types are small positive enums
+			md = protowire.AppendTag(md, 3, protowire.VarintType) //nolint:mnd // Metadata help_ref field
+			md = protowire.AppendVarint(md, uint64(t.symbolize([]byte(m.Help))))
+			md = protowire.AppendTag(md, 4, protowire.VarintType) //nolint:mnd // Metadata unit_ref field
+			md = protowire.AppendVarint(md, uint64(t.symbolize([]byte(m.Unit))))
+
+			t.series = protowire.AppendTag(t.series, 5, protowire.BytesType) //nolint:mnd // TimeSeries metadata field
+			t.series = protowire.AppendBytes(t.series, md)
+		}
+	}
+
+	out = protowire.AppendTag(out, 5, protowire.BytesType) //nolint:mnd // Request timeseries field
+
+	return protowire.AppendBytes(out, t.series), nil
+}
+
+// appendExemplar appends the 2.0 Exemplar field of the 1.0 Exemplar message e to b.
+func (t *writeV2Transcoder) appendExemplar(b, e []byte) ([]byte, error) {
+	var (
+		refs   []uint32
+		fields []byte
+	)
+
+	err := forEachField(e, func(num protowire.Number, field, value []byte) error {
+		if num != 1 { // value and timestamp, the same fields in both protocols
+			fields = append(fields, field...)
+
+			return nil
+		}
+
+		labelName, labelValue, err := consumeLabel(value)
+		if err != nil {
+			return err
+		}
+
+		refs = append(refs, t.symbolize(labelName), t.symbolize(labelValue))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exemplar := append(appendRefs(nil, refs), fields...)
+
+	b = protowire.AppendTag(b, 4, protowire.BytesType) //nolint:mnd // TimeSeries exemplars field
+
+	return protowire.AppendBytes(b, exemplar), nil
+}
+
+// symbolize returns the reference of the symbol, adding it to the symbol table if it's new.
+func (t *writeV2Transcoder) symbolize(symbol []byte) uint32 {
+	if ref, ok := t.symbols[string(symbol)]; ok {
+		return ref
+	}
+
+	ref := uint32(len(t.table)) // #nosec G115 -- a request can't hold 2^32 symbols
+	s := string(symbol)
+	t.symbols[s] = ref
+	t.table = append(t.table, s)
+
+	return ref
+}
+
+// transcodedMetadata returns the metric metadata of a 1.0 request by metric family name.
+func transcodedMetadata(req []byte) (map[string]prompb.MetricMetadata, error) {
+	var metadata map[string]prompb.MetricMetadata
+
+	err := forEachField(req, func(num protowire.Number, _, value []byte) error {
+		if num != 3 { //nolint:mnd // WriteRequest metadata field
+			return nil
+		}
+
+		var m prompb.MetricMetadata
+
+		err := m.Unmarshal(value)
+		if err != nil {
+			return err
+		}
+
+		if metadata == nil {
+			metadata = make(map[string]prompb.MetricMetadata)
+		}
+
+		metadata[m.MetricFamilyName] = m
+
+		return nil
+	})
+
+	return metadata, err
+}
+
+// appendRefs appends the labels_refs packed field of 2.0 series and exemplars to b.
+func appendRefs(b []byte, refs []uint32) []byte {
+	if len(refs) == 0 {
+		return b
+	}
+
+	size := 0
+	for _, ref := range refs {
+		size += protowire.SizeVarint(uint64(ref))
+	}
+
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(size)) // #nosec G115 -- size is always non-negative
+
+	for _, ref := range refs {
+		b = protowire.AppendVarint(b, uint64(ref))
+	}
+
+	return b
+}
+
+// consumeLabel returns the name and the value of a Label message.
+func consumeLabel(label []byte) ([]byte, []byte, error) {
+	var name, value []byte
+
+	err := forEachField(label, func(num protowire.Number, _, v []byte) error {
+		switch num {
+		case 1:
+			name = v
+		case 2: //nolint:mnd // Label value field
+			value = v
+		}
+
+		return nil
+	})
+
+	return name, value, err
+}
+
+// forEachField calls fn with the number and the whole encoded field of every field of the message,
+// along with the value of the length delimited ones.
+func forEachField(msg []byte, fn func(num protowire.Number, field, value []byte) error) error {
+	for b := msg; len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errors.Wrap(protowire.ParseError(n), "invalid remote-write request")
+		}
+
+		var value []byte
+
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			return errors.Wrap(protowire.ParseError(m), "invalid remote-write request")
+		}
+
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(b[n:])
+		}
+
+		err := fn(num, b[:n+m], value)
+		if err != nil {
+			return err
+		}
+
+		b = b[n+m:]
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package remotewrite
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+func TestToWriteV2Request(t *testing.T) {
+	t.Parallel()
+
+	req := toWriteV2Request(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "metric"},
+					{Name: "job", Value: "a"},
+				},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 10}},
+			},
+			{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "metric"},
+					{Name: "job", Value: "b"},
+				},
+				Samples: []prompb.Sample{{Value: 2, Timestamp: 20}},
+			},
+		},
+	})
+
+	require.Equal(t, []string{"", "__name__", "metric", "job", "a", "b"}, req.Symbols)
+	require.Len(t, req.Timeseries, 2)
+
+	b := labels.NewScratchBuilder(0)
+	lbls, err := req.Timeseries[1].ToLabels(&b, req.Symbols)
+	require.NoError(t, err)
+	require.Equal(t, labels.FromStrings("__name__", "metric", "job", "b"), lbls)
+	require.Equal(t, []writev2.Sample{{Value: 2, Timestamp: 20}}, req.Timeseries[1].Samples)
+}
+
+func TestTranscodeToWriteV2(t *testing.T) {
+	t.Parallel()
+
+	template, err := compileLabelTemplates(map[string]string{
+		"__name__": "metric_${series_id % 3}_total",
+		"instance": "host-${series_id}",
+	})
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic test data
+
+	samples, _, err := generateFromPrecompiledTemplates(r, 1, 10, 100_000, 0, 20, template, TemplateOptions{
+		ExemplarEvery: 3,
+		Samples:       2,
+		Step:          "15s",
+		OutOfOrder:    OutOfOrderOptions{Duplicates: 0.5},
+	})
+	require.NoError(t, err)
+	require.NoError(t, writeMetadata(samples, []Metadata{
+		{Type: "counter", MetricFamilyName: "metric_1", Help: "help", Unit: "seconds"},
+	}))
+
+	histograms, err := generateHistogramsFromPrecompiledTemplates(
+		r, 1, 10, 100_000, 0, 5, template, HistogramTemplateOptions{Schema: 2})
+	require.NoError(t, err)
+
+	described := 0
+
+	for _, buf := range []*bytes.Buffer{samples, histograms} {
+		// the transcoded request is the one of the messages
+		req := new(prompb.WriteRequest)
+		require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+
+		expected, err := proto.Marshal(protoadapt.MessageV2Of(toWriteV2Request(req)))
+		require.NoError(t, err)
+
+		transcoded, err := transcodeToWriteV2(buf.Bytes())
+		require.NoError(t, err)
+
+		var want, got writev2.Request
+
+		require.NoError(t, proto.Unmarshal(expected, protoadapt.MessageV2Of(&want)))
+		require.NoError(t, proto.Unmarshal(transcoded, protoadapt.MessageV2Of(&got)))
+		require.Equal(t, want, got)
+
+		for _, ts := range got.Timeseries {
+			if ts.Metadata.Type == writev2.Metadata_METRIC_TYPE_COUNTER {
+				described++
+			}
+		}
+	}
+
+	require.Positive(t, described)
+
+	_, err = transcodeToWriteV2([]byte{0xa, 0x10, 0x1})
+	require.Error(t, err)
+}
+
+func TestStoreV2(t *testing.T) {
+	t.Parallel()
+
+	var (
+		header http.Header
+		body   []byte
+	)
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+
+		w.Header().Set("X-Prometheus-Remote-Write-Samples-Written", "1")
+		w.Header().Set("X-Prometheus-Remote-Write-Histograms-Written", "0")
+		w.Header().Set("X-Prometheus-Remote-Write-Exemplars-Written", "0")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s", Protocol: protocolV2},
+		vu:  s.vu,
+	}
+
+	res, err := c.Store([]Timeseries{{
+		Labels:  []Label{{Name: "__name__", Value: "metric"}},
+		Samples: []Sample{{Value: 42, Timestamp: 1000}},
+	}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, res.Status)
+	require.Equal(t, int64(1), res.SamplesWritten)
+	require.Equal(t, "application/x-protobuf;proto=io.prometheus.write.v2.Request", header.Get("Content-Type"))
+	require.Equal(t, "2.0.0", header.Get("X-Prometheus-Remote-Write-Version"))
+
+	data, err := snappy.Decode(nil, body)
+	require.NoError(t, err)
+
+	var got writev2.Request
+
+	require.NoError(t, proto.Unmarshal(data, protoadapt.MessageV2Of(&got)))
+	require.Equal(t, []string{"", "__name__", "metric"}, got.Symbols)
+	require.Equal(t, []writev2.Sample{{Value: 42, Timestamp: 1000}}, got.Timeseries[0].Samples)
+}
+
+func TestStoreV2FallsBackToV1(t *testing.T) {
+	t.Parallel()
+
+	var versions []string
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+
+		versions = append(versions, r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		if r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.0.2" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s", Protocol: protocolV2},
+		vu:  s.vu,
+	}
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "metric_${series_id}"})
+	require.NoError(t, err)
+
+	res, err := c.StoreFromPrecompiledTemplates(1, 2, 1000, 0, 10, template, TemplateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.Status)
+	require.Equal(t, []string{"2.0.0", "0.0.2"}, versions)
+
+	// the fallback is remembered, the next requests are sent as 1.0 requests right away
+	res, err = c.Store([]Timeseries{{
+		Labels:  []Label{{Name: "__name__", Value: "metric"}},
+		Samples: []Sample{{Value: 1, Timestamp: 1000}},
+	}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.Status)
+
+	_, err = c.StoreFromPrecompiledTemplates(1, 2, 2000, 0, 10, template, TemplateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"2.0.0", "0.0.2", "0.0.2", "0.0.2"}, versions)
+}
@@ -0,0 +1,133 @@
+package remotewrite
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xhit/go-str2duration/v2"
+	"go.k6.io/k6/v2/lib"
+)
+
+const (
+	defaultMinBackoff = 30 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// RetryConfig configures how failed remote-write requests are retried. Network errors are retried
+// whether or not the throw option is set, and the delays of Retry-After headers are capped at max_backoff.
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of attempts for a request, 0 or 1 disables retries.
+	MaxAttempts int    `json:"max_attempts"` //nolint:tagliatelle // sobek use snake case for JSON keys
+	MinBackoff  string `json:"min_backoff"`  //nolint:tagliatelle // sobek use snake case for JSON keys
+	MaxBackoff  string `json:"max_backoff"`  //nolint:tagliatelle // sobek use snake case for JSON keys
+	//nolint:tagliatelle // sobek use snake case for JSON keys
+	RetryOn429 bool `js:"retry_on_429" json:"retry_on_429"`
+}
+
+func (rc RetryConfig) backoffs() (time.Duration, time.Duration, error) {
+	minBackoff, maxBackoff := defaultMinBackoff, defaultMaxBackoff
+
+	var err error
+
+	if rc.MinBackoff != "" {
+		minBackoff, err = str2duration.ParseDuration(rc.MinBackoff)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "invalid retry min_backoff")
+		}
+	}
+
+	if rc.MaxBackoff != "" {
+		maxBackoff, err = str2duration.ParseDuration(rc.MaxBackoff)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "invalid retry max_backoff")
+		}
+	}
+
+	if minBackoff > maxBackoff {
+		return 0, 0, errors.New("retry min_backoff must not be greater than max_backoff")
+	}
+
+	return minBackoff, maxBackoff, nil
+}
+
+// retryable reports whether a response status is worth retrying. Like Prometheus, server errors
+// and network errors (status 0) are retried, 429 only when asked for, and other 4xx never.
+func (rc RetryConfig) retryable(status int) bool {
+	switch {
+	case status == 0, status >= http.StatusInternalServerError:
+		return true
+	case status == http.StatusTooManyRequests:
+		return rc.RetryOn429
+	default:
+		return false
+	}
+}
+
+// requestError is the error of a request that got no response, such as a network error with the throw
+// option set. It's retried like the network errors reported with status 0 without it.
+type requestError struct{ error }
+
+func (e requestError) Unwrap() error { return e.error }
+
+// sendWithRetry sends the request with send, retrying it with an exponential backoff between
+// min_backoff and max_backoff. A Retry-After header in the response takes precedence over the backoff,
+// up to max_backoff so that a receiver can't stall the VU. Every attempt is a separate HTTP request
+// sample, tagged with its attempt number.
+func (c *Client) sendWithRetry(state *lib.State, protocol, contentEncoding string, req []byte) (Response, error) {
+	retry := c.cfg.Retry
+	if retry.MaxAttempts <= 1 {
+		res, err := c.send(state, protocol, contentEncoding, req, 0)
+		res.Attempts = 1
+
+		return res, err
+	}
+
+	backoff, maxBackoff, err := retry.backoffs()
+	if err != nil {
+		return newResponse(), err
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := c.send(state, protocol, contentEncoding, req, attempt)
+		res.Attempts = attempt
+
+		var reqErr requestError
+
+		failed := errors.As(err, &reqErr) || (err == nil && retry.retryable(res.Status))
+		if !failed || attempt >= retry.MaxAttempts {
+			return res, err
+		}
+
+		wait := backoff
+		if d, ok := retryAfter(res.Headers["Retry-After"]); ok {
+			wait = min(d, maxBackoff)
+		}
+
+		select {
+		case <-c.vu.Context().Done():
+			return res, c.vu.Context().Err()
+		case <-time.After(wait):
+		}
+
+		backoff = min(2*backoff, maxBackoff) //nolint:mnd // exponential backoff doubles the wait
+	}
+}
+
+// retryAfter parses a Retry-After header, either in seconds or as an HTTP date.
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,141 @@
+package remotewrite
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/v2/metrics"
+)
+
+func TestStoreRetry(t *testing.T) {
+	t.Parallel()
+
+	// status 0 closes the connection without a response
+	testcases := []struct {
+		name             string
+		statuses         []int
+		retryOn429       bool
+		throw            bool
+		retryAfter       string
+		expectedStatus   int
+		expectedAttempts int
+	}{
+		{name: "success", statuses: []int{200}, expectedStatus: 200, expectedAttempts: 1},
+		{name: "server errors", statuses: []int{503, 500, 200}, expectedStatus: 200, expectedAttempts: 3},
+		{name: "max attempts", statuses: []int{503, 503, 503, 200}, expectedStatus: 503, expectedAttempts: 3},
+		{name: "client error", statuses: []int{400, 200}, expectedStatus: 400, expectedAttempts: 1},
+		{name: "429", statuses: []int{429, 200}, expectedStatus: 429, expectedAttempts: 1},
+		{name: "429 retried", statuses: []int{429, 200}, retryOn429: true, expectedStatus: 200, expectedAttempts: 2},
+		{name: "network errors", statuses: []int{0, 0, 200}, expectedStatus: 200, expectedAttempts: 3},
+		{name: "network errors with throw", statuses: []int{0, 0, 200}, throw: true, expectedStatus: 200, expectedAttempts: 3},
+		// the receiver can't stall the VU for longer than max_backoff
+		{name: "retry after", statuses: []int{503, 200}, retryAfter: "3600", expectedStatus: 200, expectedAttempts: 2},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests int64
+
+			s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+
+				status := testcase.statuses[atomic.AddInt64(&requests, 1)-1]
+				if status == 0 {
+					panic(http.ErrAbortHandler)
+				}
+
+				if testcase.retryAfter != "" {
+					w.Header().Set("Retry-After", testcase.retryAfter)
+				}
+
+				w.WriteHeader(status)
+			})
+			samples := make(chan metrics.SampleContainer, 100)
+			s.vu.StateField.Samples = samples
+			s.vu.StateField.Options.Throw.Bool = testcase.throw
+			c := &Client{
+				cfg: &Config{
+					Url:     s.server.URL,
+					Timeout: "10s",
+					Retry: RetryConfig{
+						MaxAttempts: 3,
+						MinBackoff:  "1ms",
+						MaxBackoff:  "2ms",
+						RetryOn429:  testcase.retryOn429,
+					},
+				},
+				vu: s.vu,
+			}
+
+			res, err := c.Store([]Timeseries{{
+				Labels:  []Label{{Name: "__name__", Value: "metric"}},
+				Samples: []Sample{{Value: 42, Timestamp: 1000}},
+			}}, nil)
+			require.NoError(t, err)
+			require.Equal(t, testcase.expectedStatus, res.Status)
+			require.Equal(t, testcase.expectedAttempts, res.Attempts)
+			require.Equal(t, int64(testcase.expectedAttempts), atomic.LoadInt64(&requests))
+
+			close(samples)
+
+			// every attempt is its own HTTP request sample, tagged with its attempt number
+			var attempts []string
+
+			for container := range samples {
+				for _, sample := range container.GetSamples() {
+					if sample.Metric.Name != metrics.HTTPReqsName {
+						continue
+					}
+
+					attempt, ok := sample.Tags.Get("attempt")
+					require.True(t, ok)
+
+					attempts = append(attempts, attempt)
+				}
+			}
+
+			expected := make([]string, testcase.expectedAttempts)
+			for i := range expected {
+				expected[i] = strconv.Itoa(i + 1)
+			}
+
+			require.Equal(t, expected, attempts)
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	d, ok := retryAfter("3")
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, d)
+
+	d, ok = retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	require.True(t, ok)
+	require.InDelta(t, time.Hour, d, float64(2*time.Second))
+
+	_, ok = retryAfter("")
+	require.False(t, ok)
+
+	_, ok = retryAfter("soon")
+	require.False(t, ok)
+}
+
+func TestRetryConfigBackoffs(t *testing.T) {
+	t.Parallel()
+
+	minBackoff, maxBackoff, err := RetryConfig{}.backoffs()
+	require.NoError(t, err)
+	require.Equal(t, defaultMinBackoff, minBackoff)
+	require.Equal(t, defaultMaxBackoff, maxBackoff)
+
+	_, _, err = RetryConfig{MinBackoff: "10s", MaxBackoff: "1s"}.backoffs()
+	require.Error(t, err)
+}
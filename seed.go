@@ -0,0 +1,41 @@
+package remotewrite
+
+import (
+	"math/rand"
+	"time"
+
+	"go.k6.io/k6/v2/lib"
+)
+
+// newRand returns the random generator of the values of a request. The seed of the call, else the
+// client's, else the current time, is mixed with the VU ID, the iteration and salt, so that VUs and
+// iterations generate distinct values while a seeded run generates the same values every time.
+func (c *Client) newRand(state *lib.State, seed int64, salt int) *rand.Rand {
+	if seed == 0 {
+		seed = c.cfg.Seed
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	// The conversions only reinterpret the bits being hashed.
+	h := mix64(uint64(seed)) // #nosec G115
+	h = mix64(h ^ state.VUID)
+	h = mix64(h ^ uint64(state.Iteration)) // #nosec G115
+	h = mix64(h ^ uint64(salt))            // #nosec G115
+
+	// #nosec G404 G115 -- This is test data generation for load testing, not cryptographic use
+	return rand.New(rand.NewSource(int64(h)))
+}
+
+// mix64 is the finalizer of SplitMix64, spreading every input bit over the whole output.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
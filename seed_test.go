@@ -0,0 +1,58 @@
+package remotewrite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/v2/lib"
+)
+
+func TestNewRand(t *testing.T) {
+	t.Parallel()
+
+	values := func(c *Client, state *lib.State, seed int64, salt int) []float64 {
+		r := c.newRand(state, seed, salt)
+
+		return []float64{r.Float64(), r.Float64(), r.Float64()}
+	}
+
+	seeded := &Client{cfg: &Config{Seed: 42}}
+	state := &lib.State{VUID: 1, Iteration: 3}
+
+	// a seeded client generates the same values every run
+	require.Equal(t, values(seeded, state, 0, 0), values(seeded, &lib.State{VUID: 1, Iteration: 3}, 0, 0))
+	// but distinct ones across VUs, iterations and requests
+	require.NotEqual(t, values(seeded, state, 0, 0), values(seeded, &lib.State{VUID: 2, Iteration: 3}, 0, 0))
+	require.NotEqual(t, values(seeded, state, 0, 0), values(seeded, &lib.State{VUID: 1, Iteration: 4}, 0, 0))
+	require.NotEqual(t, values(seeded, state, 0, 0), values(seeded, state, 0, 100))
+
+	// the seed of a call overrides the client's
+	unseeded := &Client{cfg: &Config{}}
+	require.Equal(t, values(seeded, state, 7, 0), values(unseeded, state, 7, 0))
+	require.NotEqual(t, values(seeded, state, 0, 0), values(seeded, state, 7, 0))
+
+	// without seed, the values change from run to run
+	require.NotEqual(t, values(unseeded, state, 0, 0), values(unseeded, state, 0, 0))
+}
+
+func TestGenerateFromPrecompiledTemplatesSeeded(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{cfg: &Config{Seed: 42}}
+	state := &lib.State{VUID: 1}
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "metric_${series_id}"})
+	require.NoError(t, err)
+
+	first, _, err := generateFromPrecompiledTemplates(
+		c.newRand(state, 0, 0), 0, 100, 1000, 0, 10, template, TemplateOptions{ExemplarEvery: 2},
+	)
+	require.NoError(t, err)
+
+	second, _, err := generateFromPrecompiledTemplates(
+		c.newRand(state, 0, 0), 0, 100, 1000, 0, 10, template, TemplateOptions{ExemplarEvery: 2},
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, first.Bytes(), second.Bytes())
+}
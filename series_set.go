@@ -0,0 +1,177 @@
+package remotewrite
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/grafana/sobek"
+	"github.com/pkg/errors"
+	"github.com/xhit/go-str2duration/v2"
+	"go.k6.io/k6/v2/js/common"
+)
+
+// SeriesSetOptions configures a SeriesSet.
+type SeriesSetOptions struct {
+	// MinValue and MaxValue bound the generated values.
+	MinValue int
+	MaxValue int
+	// Interval is the step between the timestamps of successive writes. If empty, the current time is used,
+	// but always after the timestamp of the previous write.
+	Interval string
+	// Value selects how the values of the series are generated, randomly by default.
+	Value ValueOptions
+	// Churn rotates a fraction of the series to new series IDs over time.
+	Churn ChurnOptions
+	// OutOfOrder moves a fraction of the samples back in time, and duplicates some of them.
+	OutOfOrder OutOfOrderOptions
+	// ExemplarEvery attaches a generated exemplar to every Nth series, 0 disables exemplars.
+	ExemplarEvery int
+	// Seed overrides the client's seed.
+	Seed int64
+}
+
+// SeriesSet is a range of series generated from precompiled templates, whose values and timestamps
+// are kept from one write to the next, within a VU.
+type SeriesSet struct {
+	template    *labelTemplates
+	minSeriesID int
+	maxSeriesID int
+	options     SeriesSetOptions
+	interval    time.Duration
+
+	// mu serializes the writes, the timestamps of successive writes are strictly increasing.
+	mu   sync.Mutex
+	last int64
+}
+
+func (r *RemoteWrite) seriesSet(c sobek.ConstructorCall) *sobek.Object {
+	rt := r.vu.Runtime()
+	call, _ := sobek.AssertFunction(rt.ToValue(newSeriesSet))
+
+	v, err := call(sobek.Undefined(), c.Arguments...)
+	if err != nil {
+		common.Throw(rt, err)
+	}
+
+	return v.ToObject(rt)
+}
+
+func newSeriesSet(
+	template *labelTemplates, minSeriesID, maxSeriesID int, options SeriesSetOptions,
+) (*SeriesSet, error) {
+	if template == nil {
+		return nil, errors.New("SeriesSet expects templates precompiled with precompileLabelTemplates")
+	}
+
+	if minSeriesID >= maxSeriesID {
+		return nil, errors.New("the series ID range of a SeriesSet can't be empty")
+	}
+
+	var interval time.Duration
+
+	if options.Interval != "" {
+		var err error
+
+		interval, err = str2duration.ParseDuration(options.Interval)
+		if err != nil || interval <= 0 {
+			return nil, errors.New("the interval of a SeriesSet must be a positive duration")
+		}
+	}
+
+	// The set gets its own copy of the templates, with its own values.
+	t, err := compileLabelTemplates(template.source)
+	if err != nil {
+		return nil, err
+	}
+
+	t.values.track = true
+
+	_, err = options.Value.compile(t.values, options.MinValue, options.MaxValue)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = options.Churn.compile(minSeriesID, maxSeriesID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = options.OutOfOrder.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SeriesSet{
+		template:    t,
+		minSeriesID: minSeriesID,
+		maxSeriesID: maxSeriesID,
+		options:     options,
+		interval:    interval,
+	}, nil
+}
+
+// Write sends a sample for every series of the set, after the ones of the previous write.
+func (s *SeriesSet) Write(c *Client) (Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timestamp := s.nextTimestamp()
+
+	res, err := c.StoreFromPrecompiledTemplates(
+		s.options.MinValue, s.options.MaxValue, timestamp, s.minSeriesID, s.maxSeriesID, s.template,
+		TemplateOptions{
+			ExemplarEvery: s.options.ExemplarEvery,
+			Seed:          s.options.Seed,
+			Value:         s.options.Value,
+			Churn:         s.options.Churn,
+			OutOfOrder:    s.options.OutOfOrder,
+		},
+	)
+	if err != nil {
+		return res, err
+	}
+
+	s.last = timestamp
+
+	return res, nil
+}
+
+// nextTimestamp returns the timestamp of the next write, in milliseconds.
+func (s *SeriesSet) nextTimestamp() int64 {
+	if s.last == 0 {
+		return time.Now().UnixMilli()
+	}
+
+	if s.interval > 0 {
+		return s.last + s.interval.Milliseconds()
+	}
+
+	return max(time.Now().UnixMilli(), s.last+1)
+}
+
+// LastTimestamp returns the timestamp of the last write, 0 before the first one.
+func (s *SeriesSet) LastTimestamp() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.last
+}
+
+// LastValue returns the last value written for the series, NaN if the series wasn't written yet.
+// The value of a series rotated by churn is the one of the series replacing it in the last write.
+func (s *SeriesSet) LastValue(seriesID int) float64 {
+	values := s.template.values
+
+	values.mu.Lock()
+	defer values.mu.Unlock()
+
+	ch := churn{fraction: s.options.Churn.Fraction, size: s.maxSeriesID - s.minSeriesID}
+
+	v, ok := values.series[ch.current(values, s.minSeriesID, seriesID)]
+	if !ok {
+		return math.NaN()
+	}
+
+	return v.value
+}
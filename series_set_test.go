@@ -0,0 +1,253 @@
+package remotewrite
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+// newRecordingServer returns a test server keeping the decoded remote-write requests.
+func newRecordingServer(t *testing.T) (*testServer, func() []*prompb.WriteRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []*prompb.WriteRequest
+	)
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		data, err := snappy.Decode(nil, body)
+		require.NoError(t, err)
+
+		req := new(prompb.WriteRequest)
+		require.NoError(t, req.Unmarshal(data))
+
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return s, func() []*prompb.WriteRequest {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return requests
+	}
+}
+
+func TestSeriesSet(t *testing.T) {
+	t.Parallel()
+
+	s, requests := newRecordingServer(t)
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "counter_${series_id}"})
+	require.NoError(t, err)
+
+	set, err := newSeriesSet(template, 10, 20, SeriesSetOptions{
+		MinValue: 0,
+		MaxValue: 100,
+		Interval: "15s",
+		Value:    ValueOptions{Type: "counter"},
+	})
+	require.NoError(t, err)
+	require.Zero(t, set.LastTimestamp())
+	require.True(t, math.IsNaN(set.LastValue(10)))
+
+	for range 3 {
+		res, err := set.Write(c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.Status)
+	}
+
+	reqs := requests()
+	require.Len(t, reqs, 3)
+
+	for i := 1; i < len(reqs); i++ {
+		require.Len(t, reqs[i].Timeseries, 10)
+
+		for j, ts := range reqs[i].Timeseries {
+			previous := reqs[i-1].Timeseries[j].Samples[0]
+			require.Equal(t, previous.Timestamp+15000, ts.Samples[0].Timestamp)
+			require.Greater(t, ts.Samples[0].Value, previous.Value)
+		}
+	}
+
+	last := reqs[2].Timeseries[0]
+	require.Equal(t, last.Samples[0].Timestamp, set.LastTimestamp())
+	require.InDelta(t, last.Samples[0].Value, set.LastValue(10), 0)
+	require.True(t, math.IsNaN(set.LastValue(20)))
+}
+
+func TestSeriesSetStrictlyIncreasingTimestamps(t *testing.T) {
+	t.Parallel()
+
+	s, requests := newRecordingServer(t)
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "gauge"})
+	require.NoError(t, err)
+
+	set, err := newSeriesSet(template, 0, 1, SeriesSetOptions{MaxValue: 10})
+	require.NoError(t, err)
+
+	for range 5 {
+		_, err := set.Write(c)
+		require.NoError(t, err)
+	}
+
+	reqs := requests()
+	for i := 1; i < len(reqs); i++ {
+		require.Greater(t, reqs[i].Timeseries[0].Samples[0].Timestamp, reqs[i-1].Timeseries[0].Samples[0].Timestamp)
+	}
+}
+
+func TestSeriesSetChurn(t *testing.T) {
+	t.Parallel()
+
+	s, requests := newRecordingServer(t)
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "gauge_${series_id}"})
+	require.NoError(t, err)
+
+	set, err := newSeriesSet(template, 0, 10, SeriesSetOptions{
+		MaxValue: 10,
+		Interval: "1m",
+		Churn:    ChurnOptions{Fraction: 1, Every: "1m", StaleMarkers: true},
+	})
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err := set.Write(c)
+		require.NoError(t, err)
+	}
+
+	reqs := requests()
+	require.Len(t, reqs, 2)
+	require.Len(t, reqs[0].Timeseries, 10)
+
+	first := make(map[string]bool)
+	for _, ts := range reqs[0].Timeseries {
+		first[ts.Labels[0].Value] = true
+	}
+
+	// every series of the first write is replaced, and marked stale
+	stale := 0
+
+	for _, ts := range reqs[1].Timeseries {
+		if value.IsStaleNaN(ts.Samples[0].Value) {
+			require.True(t, first[ts.Labels[0].Value])
+
+			stale++
+		} else {
+			require.False(t, first[ts.Labels[0].Value])
+		}
+	}
+
+	require.Equal(t, 10, stale)
+	require.Len(t, reqs[1].Timeseries, 20)
+
+	// the last value of a series is the one of the series replacing it
+	generation := set.LastTimestamp() / time.Minute.Milliseconds()
+	name := "gauge_" + strconv.Itoa(churn{fraction: 1}.idAt(3, generation))
+
+	found := false
+
+	for _, ts := range reqs[1].Timeseries {
+		if ts.Labels[0].Value == name {
+			require.Equal(t, ts.Samples[0].Value, set.LastValue(3))
+
+			found = true
+		}
+	}
+
+	require.True(t, found)
+}
+
+func TestSeriesSetOutOfOrder(t *testing.T) {
+	t.Parallel()
+
+	s, requests := newRecordingServer(t)
+	c := &Client{
+		cfg: &Config{Url: s.server.URL, Timeout: "10s"},
+		vu:  s.vu,
+	}
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "gauge_${series_id}"})
+	require.NoError(t, err)
+
+	set, err := newSeriesSet(template, 0, 10, SeriesSetOptions{
+		MaxValue:   10,
+		OutOfOrder: OutOfOrderOptions{Fraction: 1, Delay: "30s", Duplicates: 1},
+	})
+	require.NoError(t, err)
+
+	res, err := set.Write(c)
+	require.NoError(t, err)
+	require.Equal(t, 10, res.OutOfOrderSamples)
+	require.Equal(t, 10, res.DuplicateSamples)
+
+	reqs := requests()
+	require.Len(t, reqs, 1)
+
+	// every sample is delayed, and followed by its duplicate
+	series := reqs[0].Timeseries
+	require.Len(t, series, 20)
+
+	for i := 0; i < len(series); i += 2 {
+		sample, duplicate := series[i].Samples[0], series[i+1].Samples[0]
+		require.Equal(t, set.LastTimestamp()-30_000, sample.Timestamp)
+		require.Equal(t, sample.Timestamp, duplicate.Timestamp)
+		require.NotEqual(t, sample.Value, duplicate.Value)
+		require.Equal(t, series[i].Labels, series[i+1].Labels)
+	}
+}
+
+func TestSeriesSetErrors(t *testing.T) {
+	t.Parallel()
+
+	template, err := compileLabelTemplates(map[string]string{"__name__": "gauge"})
+	require.NoError(t, err)
+
+	_, err = newSeriesSet(nil, 0, 1, SeriesSetOptions{})
+	require.Error(t, err)
+
+	_, err = newSeriesSet(template, 1, 1, SeriesSetOptions{})
+	require.ErrorContains(t, err, "can't be empty")
+
+	_, err = newSeriesSet(template, 0, 1, SeriesSetOptions{Interval: "-1s"})
+	require.ErrorContains(t, err, "positive duration")
+
+	_, err = newSeriesSet(template, 0, 1, SeriesSetOptions{Value: ValueOptions{Type: "square"}})
+	require.ErrorContains(t, err, "unsupported value type")
+
+	_, err = newSeriesSet(template, 0, 1, SeriesSetOptions{Churn: ChurnOptions{Fraction: 0.5}})
+	require.Error(t, err)
+
+	_, err = newSeriesSet(template, 0, 1, SeriesSetOptions{OutOfOrder: OutOfOrderOptions{Fraction: 0.5}})
+	require.Error(t, err)
+}
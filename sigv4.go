@@ -0,0 +1,285 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	// sigV4Service is the service of Amazon Managed Service for Prometheus.
+	sigV4Service = "aps"
+	// assumedRoleExpiryDelta is how long before their expiry the credentials of an assumed role are renewed.
+	assumedRoleExpiryDelta = time.Minute
+	// assumedRoleSessionName is the name of the sessions of the assumed roles.
+	assumedRoleSessionName = "k6-remote-write"
+	// maxSTSResponseSize bounds the responses of STS that are read.
+	maxSTSResponseSize = 1 << 20
+)
+
+// SigV4Config configures the AWS Signature Version 4 signing of the requests, required by Amazon
+// Managed Service for Prometheus, as in the remote_write configuration of Prometheus.
+type SigV4Config struct {
+	// Region of the workspace, the AWS_REGION environment variable by default.
+	Region string `json:"region"`
+	// AccessKey, SecretKey and SessionToken are the static credentials signing the requests, the
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables by default.
+	AccessKey    string `json:"access_key"`    //nolint:tagliatelle // sobek use snake case for JSON keys
+	SecretKey    string `json:"secret_key"`    //nolint:tagliatelle // sobek use snake case for JSON keys
+	SessionToken string `json:"session_token"` //nolint:tagliatelle // sobek use snake case for JSON keys
+	// RoleARN is a role assumed with the static credentials, whose credentials sign the requests.
+	RoleARN string `js:"role_arn" json:"role_arn"` //nolint:tagliatelle // sobek use snake case for JSON keys
+}
+
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	expiry       time.Time
+}
+
+// sigV4Signer signs the requests with static credentials, or with the ones of an assumed role.
+type sigV4Signer struct {
+	region      string
+	static      awsCredentials
+	roleARN     string
+	stsEndpoint string
+	now         func() time.Time
+
+	mu   sync.Mutex
+	role awsCredentials
+}
+
+func newSigV4Signer(cfg *SigV4Config) (*sigV4Signer, error) {
+	s := &sigV4Signer{
+		region: cfg.Region,
+		static: awsCredentials{
+			accessKey:    cfg.AccessKey,
+			secretKey:    cfg.SecretKey,
+			sessionToken: cfg.SessionToken,
+		},
+		roleARN: cfg.RoleARN,
+		now:     time.Now,
+	}
+
+	if s.region == "" {
+		s.region = os.Getenv("AWS_REGION")
+	}
+
+	if s.region == "" {
+		return nil, errors.New("sigv4 expects a region")
+	}
+
+	if s.static.accessKey == "" && s.static.secretKey == "" {
+		s.static = awsCredentials{
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+
+	if s.static.accessKey == "" || s.static.secretKey == "" {
+		return nil, errors.New("sigv4 expects an access_key and a secret_key")
+	}
+
+	s.stsEndpoint = "https://sts." + s.region + ".amazonaws.com/"
+
+	return s, nil
+}
+
+// sign signs the request, whose body is the given one. The role is assumed with the given transport.
+func (s *sigV4Signer) sign(r *http.Request, body []byte, transport http.RoundTripper) error {
+	creds, err := s.credentials(r.Context(), transport)
+	if err != nil {
+		return err
+	}
+
+	signV4(r, body, creds, s.region, sigV4Service, s.now())
+
+	return nil
+}
+
+// credentials returns the credentials signing the requests, assuming the role when there's one
+// and its credentials are about to expire.
+func (s *sigV4Signer) credentials(ctx context.Context, transport http.RoundTripper) (awsCredentials, error) {
+	if s.roleARN == "" {
+		return s.static, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.role.accessKey != "" && s.now().Before(s.role.expiry.Add(-assumedRoleExpiryDelta)) {
+		return s.role, nil
+	}
+
+	creds, err := s.assumeRole(ctx, transport)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	s.role = creds
+
+	return creds, nil
+}
+
+func (s *sigV4Signer) assumeRole(ctx context.Context, transport http.RoundTripper) (awsCredentials, error) {
+	body := []byte(url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {s.roleARN},
+		"RoleSessionName": {assumedRoleSessionName},
+	}.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.stsEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, body, s.static, s.region, "sts", s.now())
+
+	client := &http.Client{Transport: transport, Timeout: tokenRequestTimeout}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return awsCredentials{}, errors.Wrap(err, "sigv4 assume role request failed")
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxSTSResponseSize))
+	if err != nil {
+		return awsCredentials{}, errors.Wrap(err, "sigv4 assume role request failed")
+	}
+
+	if !ResponseCallback(res.StatusCode) {
+		return awsCredentials{}, errors.Errorf("sigv4 assume role request failed with status %d: %s", res.StatusCode, data)
+	}
+
+	var response struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleResult>Credentials"`
+	}
+
+	err = xml.Unmarshal(data, &response)
+	if err != nil {
+		return awsCredentials{}, errors.Wrap(err, "invalid sigv4 assume role response")
+	}
+
+	creds := response.Credentials
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return awsCredentials{}, errors.New("sigv4 assume role response has no credentials")
+	}
+
+	return awsCredentials{
+		accessKey:    creds.AccessKeyID,
+		secretKey:    creds.SecretAccessKey,
+		sessionToken: creds.SessionToken,
+		expiry:       creds.Expiration,
+	}, nil
+}
+
+// signV4 adds the AWS Signature Version 4 of the request and its body to its headers. All the
+// headers of the request are signed, except User-Agent which proxies tend to rewrite.
+func signV4(r *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	r.Header.Del("Authorization")
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	if creds.sessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if name == "user-agent" || name == "host" {
+			continue
+		}
+
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+
+		headers[name] = strings.Join(trimmed, ",")
+	}
+
+	names := slices.Sorted(maps.Keys(headers))
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		strings.ReplaceAll(r.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + creds.secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	r.Header.Set("Authorization", sigV4Algorithm+
+		" Credential="+creds.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
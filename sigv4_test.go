@@ -0,0 +1,222 @@
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// verifySigV4 checks the signature of a received request by signing again its signed headers and
+// its body with the given credentials.
+func verifySigV4(r *http.Request, body []byte, creds awsCredentials, region, service string) error {
+	authorization := r.Header.Get("Authorization")
+
+	_, signed, ok := strings.Cut(authorization, "SignedHeaders=")
+	if !ok {
+		return fmt.Errorf("unsigned request: %q", authorization)
+	}
+
+	signed, _, _ = strings.Cut(signed, ",")
+
+	date, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return err
+	}
+
+	clone, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil) //nolint:noctx // not sent
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(signed, ";") {
+		if name != "host" {
+			clone.Header[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+		}
+	}
+
+	signV4(clone, body, creds, region, service, date)
+
+	if expected := clone.Header.Get("Authorization"); expected != authorization {
+		return fmt.Errorf("invalid signature: %q, expected %q", authorization, expected)
+	}
+
+	return nil
+}
+
+func TestSignV4(t *testing.T) {
+	t.Parallel()
+
+	// get-vanilla of the AWS Signature Version 4 test suite
+	r, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+
+	creds := awsCredentials{accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(r, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	require.Equal(t, "20150830T123600Z", r.Header.Get("X-Amz-Date"))
+	require.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, "+
+			"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		r.Header.Get("Authorization"))
+}
+
+func TestSigV4(t *testing.T) {
+	t.Parallel()
+
+	creds := awsCredentials{accessKey: "AKID", secretKey: "secret", sessionToken: "session"}
+
+	var verified atomic.Int64
+
+	s := newTestServerWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = verifySigV4(r, body, creds, "eu-west-1", "aps")
+		}
+
+		if err != nil || r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		verified.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	c := &Client{
+		cfg: &Config{
+			Url:      s.server.URL + "/workspaces/ws-1/api/v1/remote_write",
+			Timeout:  "10s",
+			Headers:  map[string]string{"Authorization": "Bearer header"},
+			Protocol: "v2",
+			SigV4: &SigV4Config{
+				Region:       "eu-west-1",
+				AccessKey:    "AKID",
+				SecretKey:    "secret",
+				SessionToken: "session",
+			},
+		},
+		vu: s.vu,
+	}
+
+	res, err := c.Store([]Timeseries{{
+		Labels:  []Label{{Name: "__name__", Value: "metric"}},
+		Samples: []Sample{{Value: 1, Timestamp: 1000}},
+	}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.Status)
+	require.Equal(t, int64(1), verified.Load())
+}
+
+func TestSigV4AssumeRole(t *testing.T) {
+	t.Parallel()
+
+	static := awsCredentials{accessKey: "AKID", secretKey: "secret"}
+	role := awsCredentials{accessKey: "ROLEKEY", secretKey: "role-secret", sessionToken: "role-session"}
+
+	var assumed atomic.Int64
+
+	// the STS endpoint is only trusted by the transport of its client, given as the one of the VU
+	sts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = verifySigV4(r, body, static, "us-east-1", "sts")
+		}
+
+		if err != nil || !bytes.Contains(body, []byte("RoleArn=arn%3Aaws%3Aiam%3A%3A123456789012%3Arole%2Fwriter")) {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		assumed.Add(1)
+		_, _ = fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>%s</AccessKeyId>
+      <SecretAccessKey>%s</SecretAccessKey>
+      <SessionToken>%s</SessionToken>
+      <Expiration>2015-08-30T13:36:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`, role.accessKey, role.secretKey, role.sessionToken)
+	}))
+	t.Cleanup(sts.Close)
+
+	signer, err := newSigV4Signer(&SigV4Config{
+		Region:    "us-east-1",
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		RoleARN:   "arn:aws:iam::123456789012:role/writer",
+	})
+	require.NoError(t, err)
+
+	signer.stsEndpoint = sts.URL
+
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signer.now = func() time.Time { return now }
+
+	sign := func() *http.Request {
+		r, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "https://aps.example.com/api/v1/remote_write", nil)
+		require.NoError(t, err)
+		require.NoError(t, signer.sign(r, []byte("body"), sts.Client().Transport))
+		require.NoError(t, verifySigV4(r, []byte("body"), role, "us-east-1", "aps"))
+		require.Equal(t, "role-session", r.Header.Get("X-Amz-Security-Token"))
+
+		return r
+	}
+
+	sign()
+	sign()
+	require.Equal(t, int64(1), assumed.Load())
+
+	// the role is assumed again before its credentials expire
+	now = now.Add(time.Hour - assumedRoleExpiryDelta)
+
+	sign()
+	require.Equal(t, int64(2), assumed.Load())
+
+	signer.static.secretKey = "wrong"
+	now = now.Add(time.Hour)
+
+	err = signer.sign(new(http.Request), nil, sts.Client().Transport)
+	require.ErrorContains(t, err, "status 403")
+}
+
+//nolint:paralleltest // the test sets environment variables
+func TestSigV4Config(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	for _, cfg := range []Config{
+		{SigV4: &SigV4Config{AccessKey: "AKID", SecretKey: "secret"}},
+		{SigV4: &SigV4Config{Region: "us-east-1"}},
+		{SigV4: &SigV4Config{Region: "us-east-1", AccessKey: "AKID"}},
+		{SigV4: &SigV4Config{Region: "us-east-1", AccessKey: "AKID", SecretKey: "secret"}, BearerToken: "token"},
+	} {
+		_, err := newAuthorizer(&cfg)
+		require.Error(t, err)
+	}
+
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+
+	signer, err := newSigV4Signer(&SigV4Config{})
+	require.NoError(t, err)
+	require.Equal(t, "eu-west-1", signer.region)
+	require.Equal(t, awsCredentials{accessKey: "AKID", secretKey: "secret", sessionToken: "session"}, signer.static)
+	require.Equal(t, "https://sts.eu-west-1.amazonaws.com/", signer.stsEndpoint)
+}
@@ -13,7 +13,9 @@ export default function () {
     // Check that all exported symbols exist
     check(remote, {
         'Client constructor exists': (r) => typeof r.Client === 'function',
+        'Queue constructor exists': (r) => typeof r.Queue === 'function',
         'Sample constructor exists': (r) => typeof r.Sample === 'function',
+        'SeriesSet constructor exists': (r) => typeof r.SeriesSet === 'function',
         'Timeseries constructor exists': (r) => typeof r.Timeseries === 'function',
         'precompileLabelTemplates exists': (r) => typeof r.precompileLabelTemplates === 'function',
     });
@@ -24,9 +26,26 @@ export default function () {
     check(client, {
         'Client instance created': (c) => c !== undefined,
         'Client.store method exists': (c) => typeof c.store === 'function',
+        'Client.storeMetadata method exists': (c) => typeof c.storeMetadata === 'function',
+        'Client.storeAsync method exists': (c) => typeof c.storeAsync === 'function',
+        'Client.markStale method exists': (c) => typeof c.markStale === 'function',
+        'Client.storeFromPrecompiledTemplatesAsync method exists': (c) => typeof c.storeFromPrecompiledTemplatesAsync === 'function',
         'Client.storeGenerated method exists': (c) => typeof c.storeGenerated === 'function',
         'Client.storeFromTemplates method exists': (c) => typeof c.storeFromTemplates === 'function',
         'Client.storeFromPrecompiledTemplates method exists': (c) => typeof c.storeFromPrecompiledTemplates === 'function',
+        'Client.backfillFromPrecompiledTemplates method exists': (c) => typeof c.backfillFromPrecompiledTemplates === 'function',
+        'Client.storeHistogramsFromPrecompiledTemplates method exists': (c) => typeof c.storeHistogramsFromPrecompiledTemplates === 'function',
+    });
+
+    // Test Queue constructor
+    const queue = new remote.Queue(client, { max_shards: 2 });
+
+    check(queue, {
+        'Queue instance created': (q) => q !== undefined,
+        'Queue.append method exists': (q) => typeof q.append === 'function',
+        'Queue.flush method exists': (q) => typeof q.flush === 'function',
+        'Queue.stop method exists': (q) => typeof q.stop === 'function',
+        'Queue.stats method exists': (q) => typeof q.stats === 'function',
     });
 
     // Test precompileLabelTemplates
@@ -38,4 +57,13 @@ export default function () {
     check(compiled, {
         'precompileLabelTemplates returns object': (c) => c !== undefined && typeof c === 'object',
     });
+
+    // Test SeriesSet constructor
+    const set = new remote.SeriesSet(compiled, 0, 10, { max_value: 100, interval: '15s' });
+    check(set, {
+        'SeriesSet instance created': (s) => s !== undefined,
+        'SeriesSet.write method exists': (s) => typeof s.write === 'function',
+        'SeriesSet.lastTimestamp method exists': (s) => typeof s.lastTimestamp === 'function',
+        'SeriesSet.lastValue method exists': (s) => typeof s.lastValue === 'function',
+    });
 }
\ No newline at end of file
@@ -0,0 +1,213 @@
+package remotewrite
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xhit/go-str2duration/v2"
+)
+
+const (
+	valueRandom     = "random"
+	valueCounter    = "counter"
+	valueRandomWalk = "random_walk"
+	valueSine       = "sine"
+	valueConstant   = "constant"
+	valueStep       = "step"
+
+	defaultValuePeriod = 10 * time.Minute
+)
+
+// ValueOptions selects how the values of the series generated from templates are computed.
+// Apart from random, the generators keep the state of every series across the calls made
+// with the same precompiled templates, within a VU.
+type ValueOptions struct {
+	// Type is one of random (the default), counter, random_walk, sine, constant and step:
+	//  - random draws every value between the minimum and maximum values.
+	//  - counter starts between the minimum and maximum values and increases by Rate per second on average.
+	//  - random_walk starts between the minimum and maximum values and moves by up to Step every sample.
+	//  - sine oscillates between the minimum and maximum values every Period, with a phase per series.
+	//  - constant draws the value of a series once, between the minimum and maximum values.
+	//  - step draws a new value between the minimum and maximum values every Period.
+	Type string
+	// Rate is the average increase per second of counters, 1 if not set.
+	Rate float64
+	// ResetEvery is the interval between the resets of a counter to 0, they are never reset if empty.
+	ResetEvery string
+	// Step is the largest change of a random walk between two samples, 1% of the range if not set.
+	Step float64
+	// Period is the period of sine waves and the interval between the changes of steps, 10m if not set.
+	Period string
+}
+
+// valueGenerator computes the value of a series' sample.
+type valueGenerator func(r *rand.Rand, seriesID int, timestamp int64) float64
+
+// seriesValues keeps the state of the value generators of every series of templates across calls.
+type seriesValues struct {
+	mu     sync.Mutex
+	series map[int]seriesValue
+	// track records the last value and timestamp of every series, whatever the generator.
+	track bool
+	// generations is the last churn generation of the series ranges, by first series ID and size.
+	generations map[[2]int]int64
+}
+
+type seriesValue struct {
+	value float64
+	// last is the timestamp of the last sample, since the one of the last reset of a counter or step.
+	last  int64
+	since int64
+}
+
+func newSeriesValues() *seriesValues {
+	return &seriesValues{series: make(map[int]seriesValue), generations: make(map[[2]int]int64)}
+}
+
+// compile returns the generator of the values between minValue and maxValue, keeping its state in values.
+// The generator must be used with values.mu held.
+func (options ValueOptions) compile(values *seriesValues, minValue, maxValue int) (valueGenerator, error) {
+	next, err := options.generator(values, minValue, maxValue)
+	if err != nil || !values.track {
+		return next, err
+	}
+
+	return func(r *rand.Rand, seriesID int, timestamp int64) float64 {
+		value := next(r, seriesID, timestamp)
+
+		s := values.series[seriesID]
+		s.value = value
+		s.last = max(s.last, timestamp)
+		values.series[seriesID] = s
+
+		return value
+	}, nil
+}
+
+//nolint:funlen,cyclop // one case per type of generator
+func (options ValueOptions) generator(values *seriesValues, minValue, maxValue int) (valueGenerator, error) {
+	period, err := parseValueDuration(options.Period, defaultValuePeriod)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid value period")
+	}
+
+	resetEvery, err := parseValueDuration(options.ResetEvery, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid counter reset_every")
+	}
+
+	low, high := float64(minValue), float64(maxValue)
+
+	// state returns the state of the series, initialized with a random value when it's first seen.
+	state := func(r *rand.Rand, seriesID int, timestamp int64) (seriesValue, bool) {
+		s, ok := values.series[seriesID]
+		if !ok {
+			s = seriesValue{value: valueBetween(r, minValue, maxValue), last: timestamp, since: timestamp}
+		}
+
+		return s, ok
+	}
+
+	switch options.Type {
+	case "", valueRandom:
+		return func(r *rand.Rand, _ int, _ int64) float64 {
+			return valueBetween(r, minValue, maxValue)
+		}, nil
+	case valueConstant:
+		return func(r *rand.Rand, seriesID int, timestamp int64) float64 {
+			s, _ := state(r, seriesID, timestamp)
+			values.series[seriesID] = s
+
+			return s.value
+		}, nil
+	case valueCounter:
+		rate := options.Rate
+		if rate == 0 {
+			rate = 1
+		}
+
+		return func(r *rand.Rand, seriesID int, timestamp int64) float64 {
+			s, seen := state(r, seriesID, timestamp)
+			if !seen && resetEvery > 0 {
+				// the resets of the series are spread over the interval
+				s.since -= int64(uniform(int64(seriesID)) * float64(resetEvery.Milliseconds()))
+			}
+
+			switch {
+			case resetEvery > 0 && timestamp-s.since >= resetEvery.Milliseconds():
+				s.value = 0
+				s.since = timestamp
+			case timestamp > s.last:
+				//nolint:mnd // the increase is randomized between half and one and a half times the rate
+				s.value += rate * float64(timestamp-s.last) / 1000 * (0.5 + r.Float64())
+			}
+
+			s.last = max(s.last, timestamp)
+			values.series[seriesID] = s
+
+			return s.value
+		}, nil
+	case valueRandomWalk:
+		step := options.Step
+		if step == 0 {
+			//nolint:mnd // 1% of the range
+			step = (high - low) / 100
+		}
+
+		return func(r *rand.Rand, seriesID int, timestamp int64) float64 {
+			s, seen := state(r, seriesID, timestamp)
+			if seen {
+				s.value = min(max(s.value+step*(2*r.Float64()-1), low), high)
+			}
+
+			s.last = timestamp
+			values.series[seriesID] = s
+
+			return s.value
+		}, nil
+	case valueSine:
+		middle, amplitude := (low+high)/2, (high-low)/2 //nolint:mnd // half of the range
+
+		return func(_ *rand.Rand, seriesID int, timestamp int64) float64 {
+			phase := 2 * math.Pi * uniform(int64(seriesID))
+
+			return middle + amplitude*math.Sin(2*math.Pi*float64(timestamp)/float64(period.Milliseconds())+phase)
+		}, nil
+	case valueStep:
+		return func(r *rand.Rand, seriesID int, timestamp int64) float64 {
+			s, _ := state(r, seriesID, timestamp)
+			if timestamp-s.since >= period.Milliseconds() {
+				s.value = valueBetween(r, minValue, maxValue)
+				s.since = timestamp
+			}
+
+			s.last = timestamp
+			values.series[seriesID] = s
+
+			return s.value
+		}, nil
+	}
+
+	return nil, errors.Errorf("unsupported value type %q", options.Type)
+}
+
+func parseValueDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := str2duration.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	// the samples have millisecond timestamps, shorter durations would be rounded down to zero
+	if d < time.Millisecond {
+		return 0, errors.New("the duration must be at least 1ms")
+	}
+
+	return d, nil
+}
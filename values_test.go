@@ -0,0 +1,174 @@
+package remotewrite
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+func TestValueGenerators(t *testing.T) {
+	t.Parallel()
+
+	const minute = 60 * 1000
+
+	generate := func(t *testing.T, options ValueOptions, seriesID int, timestamps ...int64) []float64 {
+		t.Helper()
+
+		next, err := options.compile(newSeriesValues(), 100, 200)
+		require.NoError(t, err)
+
+		r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic test data
+		values := make([]float64, 0, len(timestamps))
+
+		for _, timestamp := range timestamps {
+			values = append(values, next(r, seriesID, timestamp))
+		}
+
+		return values
+	}
+
+	t.Run("random", func(t *testing.T) {
+		t.Parallel()
+
+		for _, v := range generate(t, ValueOptions{}, 0, 0, minute, 2*minute) {
+			require.GreaterOrEqual(t, v, 100.0)
+			require.Less(t, v, 200.0)
+		}
+	})
+
+	t.Run("constant", func(t *testing.T) {
+		t.Parallel()
+
+		values := generate(t, ValueOptions{Type: "constant"}, 0, 0, minute, 2*minute)
+		require.Equal(t, []float64{values[0], values[0], values[0]}, values)
+	})
+
+	t.Run("counter", func(t *testing.T) {
+		t.Parallel()
+
+		timestamps := make([]int64, 0, 60)
+		for i := range int64(60) {
+			timestamps = append(timestamps, i*minute)
+		}
+
+		values := generate(t, ValueOptions{Type: "counter", Rate: 2}, 0, timestamps...)
+		for i := 1; i < len(values); i++ {
+			require.Greater(t, values[i], values[i-1])
+		}
+
+		// 2 per second on average over 59 minutes
+		require.InEpsilon(t, 2*59*60, values[59]-values[0], 0.15)
+
+		values = generate(t, ValueOptions{Type: "counter", ResetEvery: "10m"}, 0, timestamps...)
+		resets := 0
+
+		for i := 1; i < len(values); i++ {
+			if values[i] < values[i-1] {
+				require.Zero(t, values[i])
+
+				resets++
+			}
+		}
+
+		require.InDelta(t, 6, resets, 1)
+	})
+
+	t.Run("random walk", func(t *testing.T) {
+		t.Parallel()
+
+		timestamps := make([]int64, 1000)
+		values := generate(t, ValueOptions{Type: "random_walk", Step: 5}, 0, timestamps...)
+
+		for i := 1; i < len(values); i++ {
+			require.LessOrEqual(t, math.Abs(values[i]-values[i-1]), 5.0)
+			require.GreaterOrEqual(t, values[i], 100.0)
+			require.LessOrEqual(t, values[i], 200.0)
+		}
+	})
+
+	t.Run("sine", func(t *testing.T) {
+		t.Parallel()
+
+		values := generate(t, ValueOptions{Type: "sine", Period: "1h"}, 3, 0, 15*minute, 60*minute)
+		require.InDelta(t, values[0], values[2], 1e-9)
+
+		for _, v := range values {
+			require.GreaterOrEqual(t, v, 100.0)
+			require.LessOrEqual(t, v, 200.0)
+		}
+
+		require.NotEqual(t, values, generate(t, ValueOptions{Type: "sine", Period: "1h"}, 4, 0, 15*minute, 60*minute))
+	})
+
+	t.Run("step", func(t *testing.T) {
+		t.Parallel()
+
+		values := generate(t, ValueOptions{Type: "step", Period: "5m"}, 0, 0, minute, 4*minute, 5*minute, 6*minute)
+		require.Equal(t, values[0], values[1])
+		require.Equal(t, values[0], values[2])
+		require.NotEqual(t, values[0], values[3])
+		require.Equal(t, values[3], values[4])
+	})
+}
+
+func TestValueOptionsErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := ValueOptions{Type: "square"}.compile(newSeriesValues(), 0, 1)
+	require.ErrorContains(t, err, "unsupported value type")
+
+	_, err = ValueOptions{Type: "sine", Period: "soon"}.compile(newSeriesValues(), 0, 1)
+	require.ErrorContains(t, err, "invalid value period")
+
+	_, err = ValueOptions{Type: "counter", ResetEvery: "-1m"}.compile(newSeriesValues(), 0, 1)
+	require.ErrorContains(t, err, "at least 1ms")
+
+	_, err = ValueOptions{Type: "sine", Period: "500us"}.compile(newSeriesValues(), 0, 1)
+	require.ErrorContains(t, err, "at least 1ms")
+
+	_, err = ValueOptions{Type: "step", Period: "0.5ms"}.compile(newSeriesValues(), 0, 1)
+	require.ErrorContains(t, err, "at least 1ms")
+}
+
+func TestGenerateFromTemplatesCounter(t *testing.T) {
+	t.Parallel()
+
+	compiled, err := compileLabelTemplates(map[string]string{"__name__": "counter_${series_id}"})
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(1)) //nolint:gosec // deterministic test data
+	options := TemplateOptions{Value: ValueOptions{Type: "counter", Rate: 10}}
+
+	var previous []float64
+
+	// the state of the series is kept by the templates from one call to the next
+	for i := range int64(3) {
+		buf, _, err := generateFromPrecompiledTemplates(r, 0, 100, 1000+i*15000, 0, 10, compiled, options)
+		require.NoError(t, err)
+
+		req := new(prompb.WriteRequest)
+		require.NoError(t, proto.Unmarshal(buf.Bytes(), protoadapt.MessageV2Of(req)))
+		require.Len(t, req.Timeseries, 10)
+
+		values := make([]float64, 0, len(req.Timeseries))
+		for j, ts := range req.Timeseries {
+			values = append(values, ts.Samples[0].Value)
+
+			if previous != nil {
+				require.Greater(t, values[j], previous[j])
+			}
+		}
+
+		previous = values
+	}
+
+	// copies of the templates share the state of the series
+	copied, err := compiled.acquire()
+	require.NoError(t, err)
+	require.Same(t, compiled.values, copied.values)
+}